@@ -5,8 +5,19 @@
 package ringbuffer
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"os"
+	"sort"
 	"sync"
+	"time"
+	"unicode/utf8"
 	"unsafe"
 )
 
@@ -14,6 +25,16 @@ var (
 	ErrTooManyDataToWrite = errors.New("too many data to write")
 	ErrIsFull             = errors.New("ringbuffer is full")
 	ErrIsEmpty            = errors.New("ringbuffer is empty")
+	ErrIsClosed           = errors.New("ringbuffer is closed")
+	ErrSameBuffer         = errors.New("source and destination are the same ringbuffer")
+	ErrBadMappedFile      = errors.New("bad mapped file")
+	ErrNotSupported       = errors.New("not supported on this platform")
+	ErrPaused             = errors.New("ringbuffer writes are paused")
+	ErrDestroyed          = errors.New("ringbuffer is destroyed")
+	ErrOffsetNotBuffered  = errors.New("stream offset is not buffered")
+	ErrQuotaExceeded      = errors.New("write quota exceeded")
+	ErrFillTooHigh        = errors.New("ringbuffer fill ratio too high")
+	ErrLastByteMismatch   = errors.New("last buffered byte mismatch")
 )
 
 // RingBuffer is a circular buffer that implement io.ReaderWriter interface.
@@ -23,286 +44,2175 @@ type RingBuffer struct {
 	r      int // next position to read
 	w      int // next position to write
 	isFull bool
+	closed bool
+	paused bool // see PauseWrites
+	fences int  // pending Barrier calls, writes are held back while > 0
+	block  bool // Read and Write wait for data and space, see SetBlocking
+	pow2   bool // size is a power of two, positions are wrapped with & (size-1) instead of % size
 	mu     sync.Mutex
+	cond   *sync.Cond // signaled whenever data is read, written or the buffer is closed, created by the first wait
+
+	written uint64 // total bytes ever written, the stream offset of the next byte to write
+	quota   uint64 // cap of written if > 0, see SetWriteQuota
+	readOff uint64 // total bytes ever consumed or dropped, the stream offset of the next byte to read
+
+	overwrite bool   // see SetOverwrite
+	evicted   uint64 // bytes dropped by overwrite writes
+	softLimit int    // usable capacity if > 0, see SetSoftLimit
+
+	limiter *tokenBucket // paces WriteBlocking and WriteContext, see SetWriteRateLimit
+
+	minRead int // low watermark of Read, see SetMinRead
+
+	handoff []byte // bytes of the blocked writer of a zero size buffer not taken by readers yet
+	handing bool   // a writer of a zero size buffer is handing off
+
+	fair    bool        // blocked readers and writers are served in arrival order, see SetFair
+	readers ticketQueue // blocked readers in fair mode
+	writers ticketQueue // blocked writers in fair mode
+
+	trackAge bool         // see SetAgeTracking
+	stamps   []writeStamp // when the buffered bytes were written, oldest first
+
+	destroyed bool               // see Destroy
+	freeHook  func([]byte) error // releases buf on Destroy, set by SetFreeHook
+	mapping   []byte             // the whole mmap'd file of a buffer created by NewMapped, header included
+
+	epoch uint64 // incremented when Pool.Put recycles the buffer, timer callbacks armed in an earlier epoch do nothing
+
+	idleDelay time.Duration // see SetIdleFlush
+	idleTimer *time.Timer
+	idleFn    func()
+
+	stallDelay time.Duration // see SetStallCallback
+	stallTimer *time.Timer
+	stallFn    func(bufferedBytes int)
+	stallArmed bool // stallTimer is running
+
+	maxFrame int // payload length cap of ReadFrame if > 0, see SetMaxFrameSize
+
+	jsonData bool       // include the readable bytes in MarshalJSON
+	readPool *sync.Pool // storage of ReadPooled, see SetReadPool
+
+	fullEvents chan bool // see FullnessEvents
+	lastFull   bool      // the state of the last fullness event
+
+	nonEmpty chan struct{} // closed once data is buffered, see NotifyNonEmpty
+
+	samplerStop chan struct{} // stops the goroutine of EnableFullnessSampler
+	fillSamples [10]int       // samples per decile of fullness
+
+	userData interface{} // see SetUserData
+	name     string      // see SetName
+	distNext int         // next target of DistributeTo
+
+	stage  *[stageSize]byte // bytes of WriteByteBuffered not flushed yet, allocated on first use, owned by the producer
+	staged int              // how many bytes stage holds
+
+	opLog   []Op // the last operations, nil if disabled, see EnableOpLog
+	opNext  int  // where the next operation is recorded in opLog
+	opCount int  // how many operations opLog holds
+
+	histBounds []int // upper bounds of the write size histogram buckets, nil if disabled
+	histCounts []int // len(histBounds)+1 counters, the last one for writes larger than every bound
+
+	writeHash hash.Hash // fed with every written byte, see SetWriteHash
+	readHash  hash.Hash // fed with every consumed byte, see SetReadHash
+
+	reserved int    // free space held back for the pending frame of ReserveFrame
+	frame    []byte // bytes of the pending frame, written into buf on CommitFrame
 }
 
 // New returns a new RingBuffer whose buffer has the given size.
 func New(size int) *RingBuffer {
+	return newWithBuf(make([]byte, size))
+}
+
+// newWithBuf returns a new empty RingBuffer using buf as its underlying buffer.
+func newWithBuf(buf []byte) *RingBuffer {
 	return &RingBuffer{
-		buf:  make([]byte, size),
-		size: size,
+		buf:  buf,
+		size: len(buf),
+	}
+}
+
+// NewPow2 returns a new RingBuffer whose size is minSize rounded up to the next power of two,
+// which lets it wrap positions with a bit mask instead of the slower modulo.
+func NewPow2(minSize int) *RingBuffer {
+	size := 1
+	for size < minSize {
+		size <<= 1
+	}
+	rb := New(size)
+	rb.pow2 = true
+	return rb
+}
+
+// wrap maps a position up to 2*size-1 back into buf, the caller must hold r.mu.
+// For a size above math.MaxInt/2 the position may have overflowed int, it is wrapped as the unsigned sum it really is.
+func (r *RingBuffer) wrap(i int) int {
+	if r.pow2 {
+		return i & (r.size - 1)
+	}
+	return int(uint(i) % uint(r.size))
+}
+
+// Init sets up a zero value RingBuffer, e.g. one embedded as a struct field, with a buffer of the given size.
+// A zero value RingBuffer has no capacity: writes return ErrIsFull and reads ErrIsEmpty until Init is called.
+// Init discards any buffered data and must not be called concurrently with other methods.
+func (r *RingBuffer) Init(size int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.readOff += uint64(r.length())
+	r.buf = make([]byte, size)
+	r.size = size
+	r.pow2 = false
+	r.r = 0
+	r.w = 0
+	r.isFull = false
+	r.stamps = nil
+}
+
+// NewWithAllocator returns a new RingBuffer whose buffer of the given size is obtained from alloc instead of make,
+// e.g. memory from an arena, a hugepage region or a pool. alloc must return a slice of at least size bytes.
+// Use SetFreeHook to give the memory back when the buffer is destroyed.
+func NewWithAllocator(size int, alloc func(int) []byte) *RingBuffer {
+	buf := alloc(size)
+	if len(buf) < size {
+		panic("ringbuffer: allocator returned a short buffer")
+	}
+	return newWithBuf(buf[:size])
+}
+
+// SetFreeHook sets the function Destroy calls to release the underlying buffer.
+// It is the counterpart of the allocator passed to NewWithAllocator.
+func (r *RingBuffer) SetFreeHook(fn func([]byte) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.freeHook = fn
+}
+
+// Destroy closes the ringbuffer, drops the underlying buffer and hands it to the free hook if one is set,
+// e.g. to munmap the file of NewMapped or give the memory back to an arena. From then on reads and writes
+// return ErrDestroyed instead of touching the released memory, and blocked readers and writers are woken up
+// with ErrDestroyed. Calling Destroy again is a no-op.
+func (r *RingBuffer) Destroy() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.destroyed {
+		return nil
+	}
+	r.close()
+	r.destroyed = true
+	r.readOff += uint64(r.length())
+	buf := r.buf
+	r.buf = nil
+	r.mapping = nil
+	r.size = 0
+	r.r = 0
+	r.w = 0
+	r.isFull = false
+	r.stamps = nil
+
+	if r.freeHook != nil && buf != nil {
+		return r.freeHook(buf)
+	}
+	return nil
+}
+
+// Prefault touches every page of the underlying buffer so that the pages are resident before a latency critical loop starts,
+// avoiding page fault jitter in the first Write. It is meant as a one-time setup call, e.g. right after New or NewWithAllocator.
+// Prefault writes zeros, so already written data is clobbered: call it before writing anything.
+func (r *RingBuffer) Prefault() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pageSize := os.Getpagesize()
+	for i := 0; i < r.size; i += pageSize {
+		r.buf[i] = 0
+	}
+	if r.size > 0 {
+		r.buf[r.size-1] = 0
 	}
 }
 
 // Read reads up to len(p) bytes into p. It returns the number of bytes read (0 <= n <= len(p)) and any error encountered. Even if Read returns n < len(p), it may use all of p as scratch space during the call. If some data is available but not len(p) bytes, Read conventionally returns what is available instead of waiting for more.
 // When Read encounters an error or end-of-file condition after successfully reading n > 0 bytes, it returns the number of bytes read. It may return the (non-nil) error from the same call or return the error (and n == 0) from a subsequent call.
 // Callers should always process the n > 0 bytes returned before considering the error err. Doing so correctly handles I/O errors that happen after reading some bytes and also both of the allowed EOF behaviors.
+// In blocking mode (see SetBlocking) Read returns io.EOF once the ringbuffer is closed and drained, so io.ReadFull
+// returns io.EOF if the buffer closed before any byte was read and io.ErrUnexpectedEOF with the partial count if it
+// closed in the middle, e.g. on a truncated final frame. In non-blocking mode an empty buffer returns ErrIsEmpty, closed or not.
 func (r *RingBuffer) Read(p []byte) (n int, err error) {
 	if len(p) == 0 {
 		return 0, nil
 	}
 
 	r.mu.Lock()
-	// 判空，buffer 为空则返回 err empty
-	if r.w == r.r && !r.isFull {
-		r.mu.Unlock()
-		return 0, ErrIsEmpty
-	}
-
-	if r.w > r.r {
-		n = r.w - r.r
-		if n > len(p) {
-			n = len(p)
+	if r.block && r.size == 0 {
+		// 零长度的 buffer 只能直接从阻塞的 writer 手里拿数据
+		if n, ok := r.takeHandoffBlockingMode(p); ok {
+			r.mu.Unlock()
+			return n, nil
 		}
-		copy(p, r.buf[r.r:r.r+n])
-		r.r = (r.r + n) % r.size
-		r.mu.Unlock()
-		return
 	}
-
-	n = r.size - r.r + r.w
-	if n > len(p) {
-		n = len(p)
+	if r.block {
+		r.waitBlockingMode(func() bool { return r.minReady(len(p)) })
 	}
-
-	if r.r+n <= r.size {
-		copy(p, r.buf[r.r:r.r+n])
-	} else {
-		c1 := r.size - r.r
-		copy(p, r.buf[r.r:r.size])
-		c2 := n - c1
-		copy(p[c1:], r.buf[0:c2])
+	if !r.closed && !r.minReady(len(p)) {
+		err = r.wrapErr(ErrIsEmpty)
+		r.mu.Unlock()
+		return 0, err
 	}
-	r.r = (r.r + n) % r.size
-
-	r.isFull = false
+	n, err = r.read(p)
+	if err == ErrIsEmpty && r.block && r.closed {
+		// 阻塞模式下关闭并读空之后按 io.Reader 的约定返回 io.EOF
+		err = io.EOF
+	}
+	err = r.wrapErr(err)
 	r.mu.Unlock()
 	return n, err
 }
 
-// ReadByte reads and returns the next byte from the input or ErrIsEmpty.
-func (r *RingBuffer) ReadByte() (b byte, err error) {
-	r.mu.Lock()
-	if r.w == r.r && !r.isFull {
-		r.mu.Unlock()
-		return 0, ErrIsEmpty
+// minReady reports whether a Read into a p of n bytes may return, i.e. whether at least the minimum read size
+// of SetMinRead (at most n) is buffered, or any byte without one, the caller must hold r.mu.
+func (r *RingBuffer) minReady(n int) bool {
+	need := r.minRead
+	if need > n {
+		need = n
 	}
-	b = r.buf[r.r]
-	r.r++
-	if r.r == r.size {
-		r.r = 0
+	if need < 1 {
+		need = 1
 	}
-
-	r.isFull = false
-	r.mu.Unlock()
-	return b, err
+	return r.isFull || r.length() >= need
 }
 
-// Write writes len(p) bytes from p to the underlying buf.
-// It returns the number of bytes written from p (0 <= n <= len(p)) and any error encountered that caused the write to stop early.
-// Write returns a non-nil error if it returns n < len(p).
-// Write must not modify the slice data, even temporarily.
-func (r *RingBuffer) Write(p []byte) (n int, err error) {
+// ReadNoBlock is like Read but copies at most maxBytes bytes, whatever len(p) is, bounding how long the lock is held
+// so that a huge destination does not starve writers. A maxBytes <= 0 means no cap.
+// Unlike Read it never waits, even in blocking mode: it returns ErrIsEmpty if no data is ready,
+// and on a zero size buffer it only takes bytes a blocked writer is already handing off.
+func (r *RingBuffer) ReadNoBlock(p []byte, maxBytes int) (n int, err error) {
+	if maxBytes > 0 && len(p) > maxBytes {
+		p = p[:maxBytes]
+	}
 	if len(p) == 0 {
 		return 0, nil
 	}
+
 	r.mu.Lock()
-	if r.isFull {
-		r.mu.Unlock()
-		return 0, ErrIsFull
-	}
+	defer r.mu.Unlock()
 
-	// avail : buffer 中还剩多少 byte 可写
-	var avail int
-	if r.w >= r.r {
-		avail = r.size - r.w + r.r
-	} else {
-		avail = r.r - r.w
+	if r.size == 0 && len(r.handoff) > 0 {
+		n, err = r.takeHandoff(context.Background(), p)
+		return n, r.wrapErr(err)
 	}
-
-	// 如果一次性写入的 byte 数量大于 buffer 中剩余可用 bytes 数，则用 p 把 buffer 填满，p 中剩余未写入的 buffer 的 byte 数会通过 return n, error 来通知调用者
-	if len(p) > avail {
-		err = ErrTooManyDataToWrite
-		p = p[:avail]
+	if !r.closed && !r.minReady(len(p)) {
+		return 0, r.wrapErr(ErrIsEmpty)
 	}
-	n = len(p)
-
-	// w > r 说明这一次写入，可能导致 w 跨过起点0，继续写入。所以我们要分为  w -> 0，0 -> r 2次写入。
-	// w < r 说明这一次写入，w 不会跨越 0，所以放心大胆直接 copy
-	if r.w >= r.r {
-		// c1 : w 距离终点的距离。
-		// 如果 w 里终点的距离还有 10，当前要写入4个 byte，那就直接写入，然后 w 往终点走
-		c1 := r.size - r.w
-		if c1 >= n {
-			copy(r.buf[r.w:], p)
-			r.w += n
-		// 如果 w 里终点的距离还有 10，当前要写入14个 byte。则把这11个byte 分为 {10 byte, 4byte} 两次写入。
-		// 	1. 第一次写入前 10 个byte。 - copy(buff[w:], p[:10])
-		// 	2. 第二次写入剩余 14-10(n-c1) = 4byte，  - copy(buff[0:], p[10:])
-		} else {
-			copy(r.buf[r.w:], p[:c1])
-			c2 := n - c1
-			copy(r.buf[0:], p[c1:])
-			r.w = c2
-		}
-	} else {
-		// 如果 w 落后于 r，直接写入到 buffer 里。 因为 w 写入 bytes 后，一旦碰到 r 就说明满了。  前面的代码已经确保了最多写到满为止。
-		copy(r.buf[r.w:], p)
-		r.w += n
+	n, err = r.read(p)
+	if err == ErrIsEmpty && r.block && r.closed {
+		err = io.EOF
 	}
+	return n, r.wrapErr(err)
+}
 
-	// w 走完一圈，回到了起点，归零
-	if r.w == r.size {
-		r.w = 0
+// read reads up to len(p) bytes into p, the caller must hold r.mu.
+func (r *RingBuffer) read(p []byte) (n int, err error) {
+	if r.destroyed {
+		return 0, ErrDestroyed
 	}
-
-	// 写入之后，如果 w 和 r 来到了同一个地方，则说明 buffer 满了
-	if r.w == r.r {
-		r.isFull = true
+	// 判空，buffer 为空则返回 err empty
+	if r.w == r.r && !r.isFull {
+		return 0, ErrIsEmpty
 	}
-	r.mu.Unlock()
 
-	return n, err
+	n = r.peek(p)
+	r.consume(n)
+	return n, nil
 }
 
-// WriteByte writes one byte into buffer, and returns ErrIsFull if buffer is full.
-// 当只需要写入 1 byte 时，用 WriteByte 更高效。
-// 什么情况下需要写入 1byte 呢？ 因为bytes无边界，如果你想使用 \r 或 \t \n 之类的做为消息边界，就可以用 WriteByte
-func (r *RingBuffer) WriteByte(c byte) error {
-	r.mu.Lock()
-	if r.w == r.r && r.isFull {
-		r.mu.Unlock()
-		return ErrIsFull
-	}
-	r.buf[r.w] = c
-	r.w++
+// peek copies up to len(p) readable bytes into p without moving the read pointer, the caller must hold r.mu.
+func (r *RingBuffer) peek(p []byte) int {
+	s1, s2 := r.segments()
+	n := copy(p, s1)
+	n += copy(p[n:], s2)
+	return n
+}
 
-	if r.w == r.size {
-		r.w = 0
+// segments returns the readable bytes as up to two slices of the underlying buffer, the caller must hold r.mu.
+// The second slice is only non-empty when the data wraps around the end of buf.
+// 数据没有跨越终点时只有一段 r -> r+n，跨越了终点则分为 r -> size 和 0 -> w 两段。
+func (r *RingBuffer) segments() (s1, s2 []byte) {
+	n := r.length()
+	if n == 0 {
+		return nil, nil
 	}
-	if r.w == r.r {
-		r.isFull = true
+	if c1 := r.size - r.r; n > c1 {
+		return r.buf[r.r:r.size], r.buf[0 : n-c1]
 	}
-	r.mu.Unlock()
+	return r.buf[r.r : r.r+n], nil
+}
 
-	return nil
+// consume moves the read pointer n bytes forward, the caller must hold r.mu and n must not exceed r.length().
+func (r *RingBuffer) consume(n int) {
+	if n == 0 {
+		return
+	}
+	if r.readHash != nil {
+		r.hashRange(r.readHash, r.r, n)
+	}
+	r.r = r.wrap(r.r + n)
+	r.isFull = false
+	r.readOff += uint64(n)
+	if r.trackAge {
+		r.dropStamps(n)
+	}
+	if r.opLog != nil {
+		r.logOp(OpRead, n)
+	}
+	r.resetStallTimer()
+	r.signal()
 }
 
-// Length return the length of available read bytes.
-func (r *RingBuffer) Length() int {
+// ReadUntil reads through the first occurrence of sep and returns the bytes before it, sep itself is consumed but not returned.
+// If sep is not fully buffered yet, the data is left untouched and ErrIsEmpty is returned.
+func (r *RingBuffer) ReadUntil(sep []byte) ([]byte, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	if r.w == r.r {
-		if r.isFull {
-			return r.size
-		}
-		return 0
-	}
+	return r.readUntil(sep)
+}
 
-	if r.w > r.r {
-		return r.w - r.r
+// readUntil implements ReadUntil, the caller must hold r.mu.
+func (r *RingBuffer) readUntil(sep []byte) ([]byte, error) {
+	if r.destroyed {
+		return nil, ErrDestroyed
 	}
-
-	return r.size - r.r + r.w
+	i := r.index(sep)
+	if i < 0 {
+		return nil, ErrIsEmpty
+	}
+	line := make([]byte, i)
+	r.peek(line)
+	r.consume(i + len(sep))
+	return line, nil
 }
 
-// Capacity returns the size of the underlying buffer.
-func (r *RingBuffer) Capacity() int {
-	return r.size
+// ReadLine reads through the next '\n' and returns the line without its line ending, '\n' or "\r\n".
+// A lone '\r' elsewhere in the line is kept. If no full line is buffered yet, the data is left untouched
+// and ErrIsEmpty is returned.
+func (r *RingBuffer) ReadLine() ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.readLine()
 }
 
-// Free returns the length of available bytes to write.
-// 返回 ringbuffer 里剩余可写的 bytes 数量。 只是方法名叫 Free 我开始以为是个动词，以为是释放了 ringbuffer 里的什么东西。
-func (r *RingBuffer) Free() int {
+// ReadLines consumes and returns up to max complete lines under a single lock acquisition, stripped of their
+// line endings like ReadLine, for consumers processing lines in batches. A trailing partial line stays buffered.
+// A max <= 0 means no cap. It returns ErrIsEmpty if no complete line is buffered.
+func (r *RingBuffer) ReadLines(max int) ([][]byte, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	// 当 w 与 r 相遇时，ringbuffer 不为空则为满。
-	if r.w == r.r {
-		if r.isFull {
-			return 0
+	if r.destroyed {
+		return nil, ErrDestroyed
+	}
+	var lines [][]byte
+	for max <= 0 || len(lines) < max {
+		line, err := r.readLine()
+		if err != nil {
+			break
 		}
-		return r.size
+		lines = append(lines, line)
 	}
+	if len(lines) == 0 {
+		return nil, ErrIsEmpty
+	}
+	return lines, nil
+}
 
-	if r.w < r.r {
-		return r.r - r.w
+// PeekLine returns a copy of the first line without consuming anything, e.g. to sniff the protocol of a new connection
+// before handing the untouched buffer to the real parser. If a full line is buffered, found is true and line is what
+// ReadLine would return, without its line ending. Otherwise found is false and line holds all the readable bytes.
+func (r *RingBuffer) PeekLine() (line []byte, found bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := r.index([]byte{'\n'})
+	found = n >= 0
+	if !found {
+		n = r.length()
+	}
+	line = make([]byte, n)
+	r.peek(line)
+	if n > 0 && found && line[n-1] == '\r' {
+		line = line[:n-1]
 	}
+	return line, found
+}
 
-	return r.size - r.w + r.r
+// readLine implements ReadLine, the caller must hold r.mu.
+func (r *RingBuffer) readLine() ([]byte, error) {
+	line, err := r.readUntil([]byte{'\n'})
+	if err != nil {
+		return nil, err
+	}
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return line, nil
 }
 
-// WriteString writes the contents of the string s to buffer, which accepts a slice of bytes.
-func (r *RingBuffer) WriteString(s string) (n int, err error) {
-	x := (*[2]uintptr)(unsafe.Pointer(&s))
-	h := [3]uintptr{x[0], x[1], x[1]}
-	buf := *(*[]byte)(unsafe.Pointer(&h))
-	return r.Write(buf)
+// ReadLineAppend appends the readable bytes up to and including the first delim to dst and consumes them,
+// so that a hot loop can recycle dst instead of allocating a string per line. If delim is not buffered yet,
+// all the readable bytes are appended and consumed and found is false, the caller keeps dst and calls again.
+// It returns ErrIsEmpty if the buffer is empty.
+func (r *RingBuffer) ReadLineAppend(dst []byte, delim byte) (line []byte, found bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.w == r.r && !r.isFull {
+		return dst, false, ErrIsEmpty
+	}
+	n := 0
+	s1, s2 := r.segments()
+	for _, seg := range [][]byte{s1, s2} {
+		if i := bytes.IndexByte(seg, delim); i >= 0 {
+			dst = append(dst, seg[:i+1]...)
+			n += i + 1
+			found = true
+			break
+		}
+		dst = append(dst, seg...)
+		n += len(seg)
+	}
+	r.consume(n)
+	return dst, found, nil
 }
 
-// Bytes returns all available read bytes. It does not move the read pointer and only copy the available data.
-// 返回所有 bytes，但不是以 read 的方式，只是为了一窥当前 buffer 里的所有数据长什么样。
-func (r *RingBuffer) Bytes() []byte {
+// ReadWhile consumes and returns the longest prefix of the readable bytes for which pred returns true.
+// It stops at the first byte failing pred, which is left in the buffer, or when the buffer is drained.
+// It returns nil if the first byte fails pred or the buffer is empty.
+func (r *RingBuffer) ReadWhile(pred func(byte) bool) []byte {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	// buffer 为空返回 nil，为满则 TODO
-	if r.w == r.r {
-		if r.isFull {
-			buf := make([]byte, r.size)
-			copy(buf, r.buf[r.r:])
-			copy(buf[r.size-r.r:], r.buf[:r.w])
-			return buf
+	n := 0
+	s1, s2 := r.segments()
+scan:
+	for _, seg := range [][]byte{s1, s2} {
+		for _, b := range seg {
+			if !pred(b) {
+				break scan
+			}
+			n++
 		}
+	}
+	if n == 0 {
 		return nil
 	}
 
-	if r.w > r.r {
-		buf := make([]byte, r.w-r.r)
-		copy(buf, r.buf[r.r:r.w])
-		return buf
-	}
+	p := make([]byte, n)
+	r.peek(p)
+	r.consume(n)
+	return p
+}
 
-	n := r.size - r.r + r.w
-	buf := make([]byte, n)
+// DrainBytes feeds the readable bytes one by one to f under a single lock acquisition, for byte oriented
+// state machines which would otherwise pay a lock per ReadByte. It stops when f returns false or the buffer
+// is drained, and returns how many bytes were consumed, including the one for which f returned false.
+// f must not call methods of the ringbuffer.
+func (r *RingBuffer) DrainBytes(f func(b byte) bool) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	if r.r+n < r.size {
-		copy(buf, r.buf[r.r:r.r+n])
-	} else {
-		c1 := r.size - r.r
-		copy(buf, r.buf[r.r:r.size])
-		c2 := n - c1
-		copy(buf[c1:], r.buf[0:c2])
+	n := 0
+	s1, s2 := r.segments()
+scan:
+	for _, seg := range [][]byte{s1, s2} {
+		for _, b := range seg {
+			n++
+			if !f(b) {
+				break scan
+			}
+		}
 	}
-
-	return buf
+	r.consume(n)
+	return n
 }
 
-// IsFull returns this ringbuffer is full.
-func (r *RingBuffer) IsFull() bool {
+// Index returns the offset from the read pointer of the first occurrence of sep in the readable bytes,
+// or -1 if sep is not present. Matches spanning the end of the underlying buffer are found too.
+// It does not consume anything and does not allocate unless the data wraps.
+func (r *RingBuffer) Index(sep []byte) int {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	return r.isFull
+	return r.index(sep)
 }
 
-// IsEmpty returns this ringbuffer is empty.
-// r w 指针相遇，不为满则为空
-func (r *RingBuffer) IsEmpty() bool {
+// CountByte returns how many times c appears in the readable bytes, without consuming or allocating.
+func (r *RingBuffer) CountByte(c byte) int {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	return !r.isFull && r.w == r.r
+	s1, s2 := r.segments()
+	return bytes.Count(s1, []byte{c}) + bytes.Count(s2, []byte{c})
 }
 
-// Reset the read pointer and writer pointer to zero.
-// r,w 归零归零归归零。
-func (r *RingBuffer) Reset() {
+// Tail returns a copy of the last n readable bytes, the ones written most recently, without consuming them.
+// It returns all readable bytes if n >= Length(), and nil if the buffer is empty.
+func (r *RingBuffer) Tail(n int) []byte {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.r = 0
+	length := r.length()
+	if n > length {
+		n = length
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	// 从 w 往回数 n 个 byte，可能跨越起点 0
+	p := make([]byte, n)
+	if n <= r.w {
+		copy(p, r.buf[r.w-n:r.w])
+	} else {
+		c1 := copy(p, r.buf[r.size-(n-r.w):])
+		copy(p[c1:], r.buf[:r.w])
+	}
+	return p
+}
+
+// ConsumePrefix consumes expected if the readable bytes start with it and reports whether they did.
+// Nothing is consumed on a mismatch or if fewer than len(expected) bytes are buffered.
+// Checking and consuming happen under one lock, so there is no race between a peek and a discard.
+func (r *RingBuffer) ConsumePrefix(expected []byte) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.length() < len(expected) {
+		return false
+	}
+	s1, s2 := r.segments()
+	if len(s1) >= len(expected) {
+		if !bytes.HasPrefix(s1, expected) {
+			return false
+		}
+	} else if !bytes.Equal(s1, expected[:len(s1)]) || !bytes.HasPrefix(s2, expected[len(s1):]) {
+		return false
+	}
+	r.consume(len(expected))
+	return true
+}
+
+// index returns the offset from r.r of the first occurrence of sep in the readable bytes, or -1, the caller must hold r.mu.
+func (r *RingBuffer) index(sep []byte) int {
+	if len(sep) == 0 {
+		return 0
+	}
+	s1, s2 := r.segments()
+	if i := bytes.Index(s1, sep); i >= 0 {
+		return i
+	}
+	if len(s2) == 0 {
+		return -1
+	}
+
+	// 跨越终点的匹配只可能落在 s1 的最后 len(sep)-1 个 byte 和 s2 的前 len(sep)-1 个 byte 拼起来的区间里。
+	m := len(sep) - 1
+	t1, t2 := s1, s2
+	if len(t1) > m {
+		t1 = t1[len(t1)-m:]
+	}
+	if len(t2) > m {
+		t2 = t2[:m]
+	}
+	joint := make([]byte, 0, len(t1)+len(t2))
+	joint = append(append(joint, t1...), t2...)
+	if i := bytes.Index(joint, sep); i >= 0 {
+		return len(s1) - len(t1) + i
+	}
+
+	if i := bytes.Index(s2, sep); i >= 0 {
+		return len(s1) + i
+	}
+	return -1
+}
+
+// evict drops the n oldest bytes to make room for an overwrite write, the caller must hold r.mu.
+func (r *RingBuffer) evict(n int) {
+	r.r = r.wrap(r.r + n)
+	r.isFull = false
+	r.evicted += uint64(n)
+	r.readOff += uint64(n)
+	if r.trackAge {
+		r.dropStamps(n)
+	}
+	if r.opLog != nil {
+		r.logOp(OpEvict, n)
+	}
+}
+
+// ReadVectored reads buffered bytes into bufs in order, filling each slice before moving to the next one,
+// until all of them are full or the buffer is drained. It returns the total number of bytes read,
+// or ErrIsEmpty if the buffer is empty.
+func (r *RingBuffer) ReadVectored(bufs ...[]byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.destroyed {
+		return 0, ErrDestroyed
+	}
+	if r.w == r.r && !r.isFull {
+		return 0, ErrIsEmpty
+	}
+	for _, p := range bufs {
+		c := r.peek(p)
+		r.consume(c)
+		n += c
+		if c < len(p) {
+			break
+		}
+	}
+	return n, nil
+}
+
+// FlushTo writes the buffered bytes to w and consumes the bytes w accepted, for the write-buffer pattern
+// where data accumulates in the ringbuffer and is periodically drained to a socket or a file.
+// On error the bytes w did not accept stay buffered so that the flush can be retried,
+// and a short write without error returns io.ErrShortWrite. An empty buffer is not an error.
+// Unlike an io.WriterTo it returns an int and is not used by io.Copy.
+// The lock is held while w.Write runs, so w should not block for long or touch the ringbuffer.
+func (r *RingBuffer) FlushTo(w io.Writer) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.drainTo(w, r.length())
+}
+
+// WriteToN is like FlushTo but writes at most n bytes, with up to two w.Write calls if the data wraps,
+// so that a cooperative loop feeding a slow writer can bound the work done in each iteration.
+func (r *RingBuffer) WriteToN(w io.Writer, n int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if n <= 0 {
+		return 0, nil
+	}
+	c, err := r.drainTo(w, n)
+	return int64(c), err
+}
+
+// drainTo writes up to limit readable bytes to w and consumes what w accepted, the caller must hold r.mu.
+func (r *RingBuffer) drainTo(w io.Writer, limit int) (n int, err error) {
+	s1, s2 := r.segments()
+	for _, seg := range [][]byte{s1, s2} {
+		if len(seg) > limit-n {
+			seg = seg[:limit-n]
+		}
+		if len(seg) == 0 {
+			continue
+		}
+		c, werr := w.Write(seg)
+		if c > len(seg) {
+			c = len(seg)
+		}
+		r.consume(c)
+		n += c
+		if werr != nil {
+			return n, werr
+		}
+		if c < len(seg) {
+			return n, io.ErrShortWrite
+		}
+	}
+	return n, nil
+}
+
+// FlushPending reports whether bytes written to the buffer are still waiting for a FlushTo (or a read).
+func (r *RingBuffer) FlushPending() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.length() > 0
+}
+
+// ReadByteBatch consumes and returns up to max bytes in a new slice with a single lock acquisition,
+// for byte oriented parsers which would otherwise loop on ReadByte. It returns ErrIsEmpty if the buffer is empty.
+// A max <= 0 means no cap, as for ReadNoBlock.
+func (r *RingBuffer) ReadByteBatch(max int) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.destroyed {
+		return nil, ErrDestroyed
+	}
+	n := r.length()
+	if n == 0 {
+		return nil, ErrIsEmpty
+	}
+	if max > 0 && n > max {
+		n = max
+	}
+	p := make([]byte, n)
+	r.peek(p)
+	r.consume(n)
+	return p, nil
+}
+
+// ReadLIFO consumes up to len(p) of the newest bytes, from the write end backward, turning the ringbuffer
+// into a bounded stack for undo-style workloads: p[0] is the last byte written, p[1] the one before, and so on.
+// The stream counters never move back: the bytes taken count as consumed in ReadOffset, so WriteSeq stays monotonic
+// and a pending Barrier is released, but the stream offsets of the bytes still buffered then differ by n
+// from the ones WriteSeq reported for them.
+// Mixing ReadLIFO with the FIFO reads is allowed, they just consume the data from opposite ends.
+// It returns ErrIsEmpty if the buffer is empty.
+func (r *RingBuffer) ReadLIFO(p []byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.destroyed {
+		return 0, ErrDestroyed
+	}
+	if r.w == r.r && !r.isFull {
+		return 0, ErrIsEmpty
+	}
+	n = r.length()
+	if n > len(p) {
+		n = len(p)
+	}
+	// 从 w-1 往回读，碰到起点再绕到终点
+	w := r.w
+	for i := 0; i < n; i++ {
+		if w == 0 {
+			w = r.size
+		}
+		w--
+		p[i] = r.buf[w]
+	}
+	r.w = w
+	r.isFull = false
+	r.readOff += uint64(n)
+	if r.readHash != nil {
+		r.readHash.Write(p[:n])
+	}
+	if r.trackAge {
+		r.dropNewestStamps(n)
+	}
+	if r.opLog != nil {
+		r.logOp(OpRead, n)
+	}
+	r.resetStallTimer()
+	r.signal()
+	return n, nil
+}
+
+// Read2 reads the next 2 bytes into an array, avoiding a heap allocated destination for small fixed size records.
+// Nothing is consumed and ErrIsEmpty is returned if fewer than 2 bytes are buffered.
+func (r *RingBuffer) Read2() (a [2]byte, err error) {
+	err = r.readExact(a[:])
+	return a, err
+}
+
+// Read4 is like Read2 for 4 bytes.
+func (r *RingBuffer) Read4() (a [4]byte, err error) {
+	err = r.readExact(a[:])
+	return a, err
+}
+
+// Read8 is like Read2 for 8 bytes, e.g. a fixed size header.
+func (r *RingBuffer) Read8() (a [8]byte, err error) {
+	err = r.readExact(a[:])
+	return a, err
+}
+
+// Read16 is like Read2 for 16 bytes.
+func (r *RingBuffer) Read16() (a [16]byte, err error) {
+	err = r.readExact(a[:])
+	return a, err
+}
+
+// readExact reads exactly len(p) bytes into p or nothing, p is assembled from both segments if the data wraps.
+func (r *RingBuffer) readExact(p []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.destroyed {
+		return ErrDestroyed
+	}
+	if r.length() < len(p) {
+		return ErrIsEmpty
+	}
+	r.peek(p)
+	r.consume(len(p))
+	return nil
+}
+
+// ReadByte reads and returns the next byte from the input or ErrIsEmpty.
+func (r *RingBuffer) ReadByte() (b byte, err error) {
+	r.mu.Lock()
+	if r.destroyed {
+		err = r.wrapErr(ErrDestroyed)
+		r.mu.Unlock()
+		return 0, err
+	}
+	if r.w == r.r && !r.isFull {
+		err = r.wrapErr(ErrIsEmpty)
+		r.mu.Unlock()
+		return 0, err
+	}
+	b = r.readByte()
+	r.mu.Unlock()
+	return b, err
+}
+
+// readByte consumes the next byte, the caller must hold r.mu and make sure the buffer is not empty.
+func (r *RingBuffer) readByte() byte {
+	b := r.buf[r.r]
+	r.consume(1)
+	return b
+}
+
+// Write writes len(p) bytes from p to the underlying buf.
+// It returns the number of bytes written from p (0 <= n <= len(p)) and any error encountered that caused the write to stop early.
+// Write returns a non-nil error if it returns n < len(p).
+// Write must not modify the slice data, even temporarily.
+func (r *RingBuffer) Write(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	r.mu.Lock()
+	if r.histBounds != nil {
+		r.recordWriteSize(len(p))
+	}
+	if r.block {
+		n, err = r.writeBlockingMode(p)
+	} else {
+		n, err = r.write(p)
+	}
+	err = r.wrapErr(err)
+	r.mu.Unlock()
+
+	return n, err
+}
+
+// write writes up to len(p) bytes from p to the underlying buf with the same semantics as Write, the caller must hold r.mu.
+func (r *RingBuffer) write(p []byte) (n int, err error) {
+	if r.destroyed {
+		return 0, ErrDestroyed
+	}
+	if r.closed {
+		return 0, ErrIsClosed
+	}
+	if r.writesHeld() {
+		return 0, ErrPaused
+	}
+	// 超出配额的部分不写，写完能写的再报错
+	overQuota := false
+	if r.quota > 0 {
+		if r.written >= r.quota {
+			return 0, ErrQuotaExceeded
+		}
+		if left := r.quota - r.written; uint64(len(p)) > left {
+			p = p[:left]
+			overQuota = true
+		}
+	}
+	// 覆盖模式：空间不够就丢掉最老的数据，p 比整个 buffer 还大时只保留 p 最后 size 个 byte
+	dropped := 0
+	if c := r.capacity() - r.reserved; r.overwrite && c > 0 {
+		if len(p) > c {
+			dropped = len(p) - c
+			r.evicted += uint64(dropped)
+			r.written += uint64(dropped)
+			r.readOff += uint64(dropped)
+			if r.writeHash != nil {
+				r.writeHash.Write(p[:dropped])
+			}
+			p = p[dropped:]
+		}
+		if need := len(p) - r.free(); need > 0 {
+			r.evict(need)
+		}
+	}
+	// avail : buffer 中还剩多少 byte 可写 (设置了 soft limit 时只算到 limit 为止)
+	// size 为 0 (例如零值 RingBuffer) 时 w == r 会被误判为满，直接返回
+	avail := r.free()
+	if avail == 0 {
+		return 0, ErrIsFull
+	}
+
+	// 如果一次性写入的 byte 数量大于 buffer 中剩余可用 bytes 数，则用 p 把 buffer 填满，p 中剩余未写入的 buffer 的 byte 数会通过 return n, error 来通知调用者
+	if len(p) > avail {
+		err = ErrTooManyDataToWrite
+		p = p[:avail]
+	}
+	n = len(p)
+
+	// w > r 说明这一次写入，可能导致 w 跨过起点0，继续写入。所以我们要分为  w -> 0，0 -> r 2次写入。
+	// w < r 说明这一次写入，w 不会跨越 0，所以放心大胆直接 copy
+	if r.w >= r.r {
+		// c1 : w 距离终点的距离。
+		// 如果 w 里终点的距离还有 10，当前要写入4个 byte，那就直接写入，然后 w 往终点走
+		c1 := r.size - r.w
+		if c1 >= n {
+			copy(r.buf[r.w:], p)
+			// 如果 w 里终点的距离还有 10，当前要写入14个 byte。则把这11个byte 分为 {10 byte, 4byte} 两次写入。
+			// 	1. 第一次写入前 10 个byte。 - copy(buff[w:], p[:10])
+			// 	2. 第二次写入剩余 14-10(n-c1) = 4byte，  - copy(buff[0:], p[10:])
+		} else {
+			copy(r.buf[r.w:], p[:c1])
+			copy(r.buf[0:], p[c1:])
+		}
+	} else {
+		// 如果 w 落后于 r，直接写入到 buffer 里。 因为 w 写入 bytes 后，一旦碰到 r 就说明满了。  前面的代码已经确保了最多写到满为止。
+		copy(r.buf[r.w:], p)
+	}
+	r.advance(n)
+
+	if overQuota && err == nil {
+		err = ErrQuotaExceeded
+	}
+	return n + dropped, err
+}
+
+// WriteIfBelow writes p like Write only if the buffer is filled below maxFillRatio, i.e. Length()/capacity < maxFillRatio
+// where capacity is the soft limit if set. Otherwise it writes nothing and returns ErrFillTooHigh, so that a producer can shed
+// new data under pressure. The check and the write are done atomically.
+func (r *RingBuffer) WriteIfBelow(p []byte, maxFillRatio float64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c := r.capacity(); c == 0 || float64(r.length())/float64(c) >= maxFillRatio {
+		return 0, ErrFillTooHigh
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return r.write(p)
+}
+
+// WriteIfLastByte writes p like Write only if the newest unread byte equals expected, e.g. to append a message
+// only right after a delimiter. Otherwise it writes nothing and returns ErrLastByteMismatch.
+// An empty buffer has no last byte: it returns ErrIsEmpty, the first message is written with Write.
+// The check and the write are done atomically.
+func (r *RingBuffer) WriteIfLastByte(expected byte, p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.w == r.r && !r.isFull {
+		return 0, ErrIsEmpty
+	}
+	last := r.w - 1
+	if last < 0 {
+		last = r.size - 1
+	}
+	if r.buf[last] != expected {
+		return 0, ErrLastByteMismatch
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return r.write(p)
+}
+
+// WouldFit reports whether n bytes can be written right now, i.e. n <= Free(), e.g. before composing an expensive message.
+// Another writer may still take the space before the caller writes, use WriteFull to check and write at once.
+func (r *RingBuffer) WouldFit(n int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return n <= r.free()
+}
+
+// WriteFull writes all of p or nothing, checking the free space and writing under a single lock acquisition.
+// It returns ErrTooManyDataToWrite if p is larger than the capacity, ErrIsFull if there is not enough free space
+// right now and ErrQuotaExceeded if p does not fit in the write quota. In overwrite mode old data is evicted to make room.
+func (r *RingBuffer) WriteFull(p []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	free := r.free()
+	if r.overwrite {
+		free = r.capacity() - r.reserved
+	}
+	if err := r.checkFrameWrite(len(p), free); err != nil || len(p) == 0 {
+		return err
+	}
+	_, err := r.write(p)
+	return err
+}
+
+// WriteSeq is like Write but also returns the stream offset of the first byte of p,
+// that is the total number of bytes written to the buffer before this write.
+// Readers can correlate the offsets they consume with it, see ReadOffset. The offsets are only exact as long as
+// the bytes are consumed in order: ReadLIFO shifts the offsets of the bytes it leaves buffered.
+func (r *RingBuffer) WriteSeq(p []byte) (n int, seq uint64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	seq = r.written
+	if len(p) == 0 {
+		return 0, seq, nil
+	}
+	n, err = r.write(p)
+	return n, seq, err
+}
+
+// ReadOffset returns the stream offset of the next byte to read, that is the total number of bytes consumed
+// so far, including bytes dropped by overwrite writes or resets. Together with WriteSeq it allows offset based
+// acknowledgment: the buffered bytes are always those from ReadOffset up to the offset of the next write.
+func (r *RingBuffer) ReadOffset() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.readOff
+}
+
+// SetWriteQuota caps the total number of bytes ever written at total, for metered ingestion:
+// a write crossing the quota writes what is left of it and returns ErrQuotaExceeded, and once the quota
+// is reached Write and WriteByte only return ErrQuotaExceeded. Bytes written before the call count toward it.
+// A zero total removes the quota.
+func (r *RingBuffer) SetWriteQuota(total uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.quota = total
+}
+
+// HasOffset reports whether the byte at stream offset seq is still buffered, i.e. it has been written
+// but not consumed, dropped by an overwrite write or reset yet: ReadOffset() <= seq < the offset of the next write.
+// A reconnecting consumer can use it to know whether it can resume from seq.
+func (r *RingBuffer) HasOffset(seq uint64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return seq >= r.readOff && seq < r.written
+}
+
+// SeekToOffset moves the read position to the byte at stream offset seq, discarding the bytes before it.
+// seq must be buffered (see HasOffset) or be the offset of the next write, which drains the buffer,
+// otherwise ErrOffsetNotBuffered is returned and nothing changes. Consumed bytes cannot be read again.
+func (r *RingBuffer) SeekToOffset(seq uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if seq < r.readOff || seq > r.written {
+		return ErrOffsetNotBuffered
+	}
+	r.consume(int(seq - r.readOff))
+	return nil
+}
+
+// WriteReporting is like Write but also returns the tail of p which was not written, as a view into p,
+// so a caller logging or retrying the dropped bytes does not need to recompute p[n:]. overflow is nil when all of p was written.
+func (r *RingBuffer) WriteReporting(p []byte) (written int, overflow []byte, err error) {
+	written, err = r.Write(p)
+	if written < len(p) {
+		overflow = p[written:]
+	}
+	return written, overflow, err
+}
+
+// WriteFront inserts p ahead of the buffered data, so that the next read returns p first, e.g. for an out-of-band
+// control message in an otherwise FIFO stream. p is written all at once or not at all: it returns ErrTooManyDataToWrite
+// if p is larger than the free space, which always lies right before the read position, and ErrQuotaExceeded
+// if p would exceed the write quota.
+// The bytes of p take the stream offsets from ReadOffset on, the buffered bytes are shifted after them.
+// 相当于把 r 往回挪 len(p)，空闲区域 w -> r 是连续的，所以只要 free 够就一定放得下
+func (r *RingBuffer) WriteFront(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.destroyed {
+		return 0, ErrDestroyed
+	}
+	if r.closed {
+		return 0, ErrIsClosed
+	}
+	if r.writesHeld() {
+		return 0, ErrPaused
+	}
+	n := len(p)
+	if n == 0 {
+		return 0, nil
+	}
+	if r.quota > 0 && r.written+uint64(n) > r.quota {
+		return 0, ErrQuotaExceeded
+	}
+	if n > r.free() {
+		return 0, ErrTooManyDataToWrite
+	}
+
+	// 新的 r = r - n，可能要绕回终点
+	start := r.r - n
+	if start < 0 {
+		start += r.size
+		c := copy(r.buf[start:], p)
+		copy(r.buf, p[c:])
+	} else {
+		copy(r.buf[start:], p)
+	}
+	r.r = start
+	if r.r == r.w {
+		r.isFull = true
+	}
+	r.written += uint64(n)
+	if r.writeHash != nil {
+		r.writeHash.Write(p)
+	}
+	if r.trackAge {
+		r.stamps = append([]writeStamp{{n: n, t: time.Now()}}, r.stamps...)
+	}
+	if r.opLog != nil {
+		r.logOp(OpWrite, n)
+	}
+	r.resetIdleTimer()
+	r.armStallTimer()
+	r.signal()
+	return n, nil
+}
+
+// advance moves the write pointer n bytes forward once they have been copied into buf, the caller must hold r.mu.
+func (r *RingBuffer) advance(n int) {
+	if n == 0 {
+		return
+	}
+	if r.writeHash != nil {
+		r.hashRange(r.writeHash, r.w, n)
+	}
+	// w 走完一圈，回到了起点，归零
+	r.w = r.wrap(r.w + n)
+
+	// 写入之后，如果 w 和 r 来到了同一个地方，则说明 buffer 满了
+	if r.w == r.r {
+		r.isFull = true
+	}
+	r.written += uint64(n)
+	if r.trackAge {
+		r.stamps = append(r.stamps, writeStamp{n: n, t: time.Now()})
+	}
+	if r.opLog != nil {
+		r.logOp(OpWrite, n)
+	}
+	r.resetIdleTimer()
+	r.armStallTimer()
+	r.signal()
+}
+
+// WriteSome writes as many bytes from p as fit right now and returns how many were written,
+// a short write is not an error, so the caller can advance its own offset and retry the rest later.
+// It only returns ErrIsFull when nothing could be written. It never blocks and never allocates.
+func (r *RingBuffer) WriteSome(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	r.mu.Lock()
+	n, err = r.write(p)
+	r.mu.Unlock()
+
+	if err == ErrTooManyDataToWrite {
+		err = nil
+	}
+	return n, err
+}
+
+// WriteByte writes one byte into buffer, and returns ErrIsFull if buffer is full.
+// In overwrite mode it evicts the oldest byte instead.
+// 当只需要写入 1 byte 时，用 WriteByte 更高效。
+// 什么情况下需要写入 1byte 呢？ 因为bytes无边界，如果你想使用 \r 或 \t \n 之类的做为消息边界，就可以用 WriteByte
+func (r *RingBuffer) WriteByte(c byte) error {
+	r.mu.Lock()
+	err := r.wrapErr(r.writeByte(c))
+	r.mu.Unlock()
+
+	return err
+}
+
+// writeByte writes one byte into buffer with the same semantics as WriteByte, the caller must hold r.mu.
+func (r *RingBuffer) writeByte(c byte) error {
+	if r.destroyed {
+		return ErrDestroyed
+	}
+	if r.closed {
+		return ErrIsClosed
+	}
+	if r.writesHeld() {
+		return ErrPaused
+	}
+	if r.quota > 0 && r.written >= r.quota {
+		return ErrQuotaExceeded
+	}
+	// 覆盖模式：满了就丢掉最老的 1 byte
+	if r.overwrite && r.capacity() > r.reserved && r.free() == 0 {
+		r.evict(1)
+	}
+	if r.free() == 0 {
+		return ErrIsFull
+	}
+	r.buf[r.w] = c
+	r.advance(1)
+
+	return nil
+}
+
+// stageSize is how many bytes WriteByteBuffered accumulates before flushing them.
+const stageSize = 64
+
+// WriteByteBuffered is like WriteByte but accumulates the bytes in a small staging array and only writes them
+// to the buffer, under a single lock, every 64 bytes or on FlushBytes. This amortizes the lock of a tight WriteByte loop.
+// The staged bytes are not readable until they are flushed. When the buffer is full the bytes stay staged and
+// the flush is retried on the next call, ErrIsFull (or the error of Write) is returned once the staging array is full too.
+// The staging array is not synchronized: WriteByteBuffered and FlushBytes must only be called by a single producer.
+func (r *RingBuffer) WriteByteBuffered(c byte) error {
+	if r.stage == nil {
+		r.stage = new([stageSize]byte)
+	}
+	if r.staged == len(r.stage) {
+		if err := r.FlushBytes(); err != nil && r.staged == len(r.stage) {
+			return err
+		}
+	}
+	r.stage[r.staged] = c
+	r.staged++
+	if r.staged == len(r.stage) {
+		// 写不进去的部分留在 staging 里，下一次再试
+		r.FlushBytes()
+	}
+	return nil
+}
+
+// FlushBytes writes the bytes staged by WriteByteBuffered to the buffer, making them readable.
+// The bytes which do not fit stay staged and Write's error is returned.
+func (r *RingBuffer) FlushBytes() error {
+	if r.staged == 0 {
+		return nil
+	}
+	r.mu.Lock()
+	n, err := r.write(r.stage[:r.staged])
+	r.mu.Unlock()
+
+	r.staged = copy(r.stage[:], r.stage[n:r.staged])
+	return err
+}
+
+// Length return the length of available read bytes.
+func (r *RingBuffer) Length() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.length()
+}
+
+// length returns the length of available read bytes, the caller must hold r.mu.
+func (r *RingBuffer) length() int {
+	if r.w == r.r {
+		if r.isFull {
+			return r.size
+		}
+		return 0
+	}
+
+	if r.w > r.r {
+		return r.w - r.r
+	}
+
+	return r.size - r.r + r.w
+}
+
+// Capacity returns the size of the underlying buffer.
+func (r *RingBuffer) Capacity() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.size
+}
+
+// Free returns the length of available bytes to write.
+// 返回 ringbuffer 里剩余可写的 bytes 数量。 只是方法名叫 Free 我开始以为是个动词，以为是释放了 ringbuffer 里的什么东西。
+func (r *RingBuffer) Free() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.free()
+}
+
+// free returns the length of available bytes to write up to the soft limit, minus the space reserved
+// by ReserveFrame, the caller must hold r.mu.
+func (r *RingBuffer) free() int {
+	n := r.space()
+	if n <= r.reserved {
+		return 0
+	}
+	return n - r.reserved
+}
+
+// space returns the length of available bytes to write up to the soft limit, reserved space included,
+// the caller must hold r.mu.
+func (r *RingBuffer) space() int {
+	if r.softLimit > 0 {
+		if n := r.softLimit - r.length(); n > 0 {
+			return n
+		}
+		return 0
+	}
+
+	// 当 w 与 r 相遇时，ringbuffer 不为空则为满。
+	if r.w == r.r {
+		if r.isFull {
+			return 0
+		}
+		return r.size
+	}
+
+	if r.w < r.r {
+		return r.r - r.w
+	}
+
+	return r.size - r.w + r.r
+}
+
+// capacity returns how many bytes writers may buffer, the soft limit if set or the size, the caller must hold r.mu.
+func (r *RingBuffer) capacity() int {
+	if r.softLimit > 0 {
+		return r.softLimit
+	}
+	return r.size
+}
+
+// SetSoftLimit caps the usable capacity at n bytes, below the size of the underlying buffer,
+// e.g. to keep headroom for a control channel. Write and WriteByte treat the buffer as full once n bytes are buffered,
+// Free and IsFull report space and fullness relative to n, while Length and Capacity are unaffected.
+// A n <= 0 or n >= Capacity() removes the limit.
+func (r *RingBuffer) SetSoftLimit(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if n <= 0 || n >= r.size {
+		n = 0
+	}
+	r.softLimit = n
+	r.signal()
+}
+
+// Usage returns the length of available read bytes, the length of available bytes to write and
+// the usable capacity, all taken under a single lock so they are consistent with each other: used+free == capacity.
+// The capacity is the soft limit if one is set, less the space held by ReserveFrame. Only right after SetSoftLimit
+// lowers the limit below the buffered bytes is used larger than capacity, with free 0, until readers catch up.
+// 分别调用 Length() 和 Free() 要加两次锁，两次调用之间如果有写入，两个值加起来就不等于 capacity 了。
+func (r *RingBuffer) Usage() (used, free, capacity int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.length(), r.free(), r.capacity() - r.reserved
+}
+
+// EnableWriteSizeHistogram starts tallying the sizes of Write calls into buckets,
+// each bucket counts the writes whose size is at most its bound and larger than the previous bound.
+// Writes larger than every bound are counted under math.MaxInt. Duplicate bounds count as one.
+// Calling it again resets the counters, an empty buckets disables the histogram.
+func (r *RingBuffer) EnableWriteSizeHistogram(buckets []int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(buckets) == 0 {
+		r.histBounds, r.histCounts = nil, nil
+		return
+	}
+	bounds := append([]int(nil), buckets...)
+	sort.Ints(bounds)
+	// 去掉重复的 bound，否则 WriteSizeHistogram 的 map 里后一个 bucket 会覆盖前一个;
+	// math.MaxInt 本身就是最后那个 bucket 的 key
+	uniq := bounds[:0]
+	for i, b := range bounds {
+		if (i == 0 || b != bounds[i-1]) && b != math.MaxInt {
+			uniq = append(uniq, b)
+		}
+	}
+	r.histBounds = uniq
+	r.histCounts = make([]int, len(r.histBounds)+1)
+}
+
+// WriteSizeHistogram returns how many Write calls fell into each bucket set by EnableWriteSizeHistogram,
+// keyed by the bucket bound. It returns nil if the histogram is disabled.
+func (r *RingBuffer) WriteSizeHistogram() map[int]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.histBounds == nil {
+		return nil
+	}
+	m := make(map[int]int, len(r.histCounts))
+	for i, b := range r.histBounds {
+		m[b] = r.histCounts[i]
+	}
+	m[math.MaxInt] = r.histCounts[len(r.histBounds)]
+	return m
+}
+
+// recordWriteSize counts a write of n bytes into the histogram, the caller must hold r.mu.
+func (r *RingBuffer) recordWriteSize(n int) {
+	i := sort.SearchInts(r.histBounds, n)
+	r.histCounts[i]++
+}
+
+// AppendBuffer moves all readable bytes of other into r, copying directly between the two underlying buffers.
+// It returns how many bytes were transferred; if r fills up the rest stays in other and the error of Write is returned.
+func (r *RingBuffer) AppendBuffer(other *RingBuffer) (int, error) {
+	if other == r {
+		return 0, ErrSameBuffer
+	}
+	lockPair(r, other)
+	defer unlockPair(r, other)
+
+	return r.transfer(other, other.length())
+}
+
+// Splice moves up to n readable bytes of src into r by copying directly between the two underlying buffers,
+// advancing the read pointer of src and the write pointer of r, without an intermediate buffer.
+// It returns how many bytes were moved, with ErrIsEmpty if src is empty or the error of Write if r fills up.
+func (r *RingBuffer) Splice(src *RingBuffer, n int) (int, error) {
+	if src == r {
+		return 0, ErrSameBuffer
+	}
+	if n <= 0 {
+		return 0, nil
+	}
+	lockPair(r, src)
+	defer unlockPair(r, src)
+
+	if src.w == src.r && !src.isFull {
+		return 0, ErrIsEmpty
+	}
+	return r.transfer(src, n)
+}
+
+// DistributeTo moves up to chunk readable bytes into the next of targets in rotation, for a fan-out stage
+// spreading a stream over worker buffers. A target without free space is skipped for the next one, and a target
+// with less than chunk bytes free receives what fits. It never blocks: it returns ErrIsFull when every target is full,
+// and ErrIsEmpty if r is empty. The rotation position is kept in r across calls.
+// Each move locks r and one target in address order, like Splice, so concurrent distributions cannot deadlock.
+func (r *RingBuffer) DistributeTo(targets []*RingBuffer, chunk int) (int, error) {
+	if chunk <= 0 || len(targets) == 0 {
+		return 0, nil
+	}
+	for _, t := range targets {
+		if t == r {
+			return 0, ErrSameBuffer
+		}
+	}
+
+	for tries := 0; tries < len(targets); tries++ {
+		r.mu.Lock()
+		i := r.distNext % len(targets)
+		r.distNext = i + 1
+		r.mu.Unlock()
+
+		t := targets[i]
+		lockPair(r, t)
+		if r.w == r.r && !r.isFull {
+			unlockPair(r, t)
+			return 0, ErrIsEmpty
+		}
+		if t.free() == 0 || t.closed || t.writesHeld() {
+			// 这个 target 写不进去，换下一个
+			unlockPair(r, t)
+			continue
+		}
+		n, err := t.transfer(r, chunk)
+		unlockPair(r, t)
+		if err == ErrTooManyDataToWrite {
+			err = nil
+		}
+		return n, err
+	}
+	return 0, ErrIsFull
+}
+
+// transfer moves up to n readable bytes of src into r, the caller must hold the locks of both.
+func (r *RingBuffer) transfer(src *RingBuffer, n int) (moved int, err error) {
+	s1, s2 := src.segments()
+	for _, seg := range [][]byte{s1, s2} {
+		if len(seg) > n-moved {
+			seg = seg[:n-moved]
+		}
+		if len(seg) == 0 {
+			break
+		}
+		var c int
+		c, err = r.write(seg)
+		src.consume(c)
+		moved += c
+		if err != nil {
+			return moved, err
+		}
+	}
+	return moved, nil
+}
+
+// lockPair locks two different ringbuffers in address order, so that two goroutines locking the same pair never deadlock.
+func lockPair(a, b *RingBuffer) {
+	if uintptr(unsafe.Pointer(a)) > uintptr(unsafe.Pointer(b)) {
+		a, b = b, a
+	}
+	a.mu.Lock()
+	b.mu.Lock()
+}
+
+// unlockPair unlocks two ringbuffers locked by lockPair.
+func unlockPair(a, b *RingBuffer) {
+	a.mu.Unlock()
+	b.mu.Unlock()
+}
+
+// WriteString writes the contents of the string s to buffer, which accepts a slice of bytes.
+func (r *RingBuffer) WriteString(s string) (n int, err error) {
+	return r.Write(stringBytes(s))
+}
+
+// WriteFrom lets src push its bytes into the buffer through Write, e.g. a bytes.Buffer.
+// When the buffer fills up it returns the number of bytes written so far and the error of Write,
+// ErrTooManyDataToWrite or ErrIsFull, a src like bytes.Buffer keeps the remaining bytes for a later call.
+func (r *RingBuffer) WriteFrom(src io.WriterTo) (int64, error) {
+	return src.WriteTo(r)
+}
+
+// WriteStringFull writes all of s or nothing, it returns ErrTooManyDataToWrite if s is larger than the capacity
+// and ErrIsFull if there is not enough free space right now. In overwrite mode old data is evicted to make room.
+func (r *RingBuffer) WriteStringFull(s string) error {
+	return r.WriteFull(stringBytes(s))
+}
+
+// WriteStringTruncateRune is like WriteString but when s does not fit it only writes the longest prefix of s
+// ending at a rune boundary, so that a multibyte UTF-8 sequence is never split. It returns the number of bytes written,
+// which is where the caller should resume, and ErrTooManyDataToWrite (ErrIsFull if nothing was written) when s is truncated,
+// or ErrQuotaExceeded when it is truncated by the write quota.
+func (r *RingBuffer) WriteStringTruncateRune(s string) (n int, err error) {
+	if len(s) == 0 {
+		return 0, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	avail := r.free()
+	if r.overwrite {
+		avail = r.capacity() - r.reserved
+	}
+	// 配额也要在找 rune 边界之前算进去，否则 write 会按 byte 再截断一次
+	cutErr := ErrTooManyDataToWrite
+	if r.quota > 0 {
+		left := uint64(0)
+		if r.written < r.quota {
+			left = r.quota - r.written
+		}
+		if uint64(avail) > left {
+			avail = int(left)
+			cutErr = ErrQuotaExceeded
+		}
+	}
+	if len(s) > avail {
+		// 从可写的位置往前退，直到一个 rune 的起始 byte
+		cut := avail
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			switch {
+			case r.destroyed:
+				return 0, ErrDestroyed
+			case r.closed:
+				return 0, ErrIsClosed
+			case r.writesHeld():
+				return 0, ErrPaused
+			}
+			if cutErr == ErrQuotaExceeded {
+				return 0, ErrQuotaExceeded
+			}
+			return 0, ErrIsFull
+		}
+		s = s[:cut]
+		err = cutErr
+	}
+	n, werr := r.write(stringBytes(s))
+	if werr != nil {
+		return n, werr
+	}
+	return n, err
+}
+
+// stringBytes returns the bytes of s without copying them, they must not be modified.
+func stringBytes(s string) []byte {
+	x := (*[2]uintptr)(unsafe.Pointer(&s))
+	h := [3]uintptr{x[0], x[1], x[1]}
+	return *(*[]byte)(unsafe.Pointer(&h))
+}
+
+// Bytes returns all available read bytes. It does not move the read pointer and only copy the available data.
+// 返回所有 bytes，但不是以 read 的方式，只是为了一窥当前 buffer 里的所有数据长什么样。
+func (r *RingBuffer) Bytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// buffer 为空返回 nil，为满则 TODO
+	if r.w == r.r {
+		if r.isFull {
+			buf := make([]byte, r.size)
+			copy(buf, r.buf[r.r:])
+			copy(buf[r.size-r.r:], r.buf[:r.w])
+			return buf
+		}
+		return nil
+	}
+
+	if r.w > r.r {
+		buf := make([]byte, r.w-r.r)
+		copy(buf, r.buf[r.r:r.w])
+		return buf
+	}
+
+	n := r.size - r.r + r.w
+	buf := make([]byte, n)
+
+	if r.r+n < r.size {
+		copy(buf, r.buf[r.r:r.r+n])
+	} else {
+		c1 := r.size - r.r
+		copy(buf, r.buf[r.r:r.size])
+		c2 := n - c1
+		copy(buf[c1:], r.buf[0:c2])
+	}
+
+	return buf
+}
+
+// BytesNoCopy is like Bytes but avoids the copy when the readable bytes are contiguous.
+// It returns true if the slice is a zero-copy view and false if the data wraps and had to be copied.
+//
+// WARNING: a zero-copy view aliases the underlying buffer. It must not be modified, and it is only valid
+// until the next write or read: later writes may overwrite the bytes it shows while the caller is looking at them.
+func (r *RingBuffer) BytesNoCopy() ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s1, s2 := r.segments()
+	if len(s2) == 0 {
+		return s1, true
+	}
+	buf := make([]byte, len(s1)+len(s2))
+	copy(buf[copy(buf, s1):], s2)
+	return buf, false
+}
+
+// Chunks returns a copy of the readable bytes split into consecutive chunks of size bytes, the last one possibly shorter,
+// without consuming them, e.g. to inspect fixed size records or cipher blocks. The chunks share a single allocation
+// but are capped, so appending to one does not overwrite the next. It returns nil if the buffer is empty or size <= 0.
+func (r *RingBuffer) Chunks(size int) [][]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := r.length()
+	if n == 0 || size <= 0 {
+		return nil
+	}
+	buf := make([]byte, n)
+	r.peek(buf)
+
+	chunks := make([][]byte, 0, (n+size-1)/size)
+	for len(buf) > size {
+		chunks = append(chunks, buf[:size:size])
+		buf = buf[size:]
+	}
+	return append(chunks, buf)
+}
+
+// ReadView returns up to max contiguous readable bytes as a view into the underlying buffer, without copying them,
+// and a commit function consuming them once the caller is done, e.g. for a decoder processing each chunk in place.
+// When the data wraps only the part before the end of the buffer is returned, the rest comes with the next call.
+// A max <= 0 means no cap. It returns a nil view and a no-op commit if the buffer is empty.
+//
+// WARNING: the view aliases the underlying buffer and must not be modified. It is invalid after commit.
+// Until then writes do not touch it, except in overwrite mode. If other bytes were consumed or dropped in between,
+// or bytes were inserted ahead of them by WriteFront, commit does nothing, and calling it more than once has no effect either.
+func (r *RingBuffer) ReadView(max int) ([]byte, func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	view, _ := r.segments()
+	if max > 0 && len(view) > max {
+		view = view[:max:max]
+	}
+	if len(view) == 0 {
+		return nil, func() {}
+	}
+	off, pos := r.readOff, r.r
+	commit := func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		// 期间有别的读取、丢弃或 WriteFront，视图已经失效
+		if r.readOff != off || r.r != pos || r.length() < len(view) {
+			return
+		}
+		r.consume(len(view))
+	}
+	return view, commit
+}
+
+// PeekFunc calls f with the first n readable bytes, or all of them if fewer are buffered, as up to two segments
+// of the underlying buffer: seg2 is only non-empty when the data wraps. Nothing is consumed or copied.
+// f runs with the lock held, so the segments cannot change under it, but f must not block
+// and must not call methods of the ringbuffer. The segments must not be used after f returns.
+func (r *RingBuffer) PeekFunc(n int, f func(seg1, seg2 []byte)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s1, s2 := r.segments()
+	if n < 0 {
+		n = 0
+	}
+	if n <= len(s1) {
+		s1, s2 = s1[:n], nil
+	} else if n-len(s1) < len(s2) {
+		s2 = s2[:n-len(s1)]
+	}
+	f(s1, s2)
+}
+
+// SetJSONIncludeData sets whether MarshalJSON includes a base64 copy of the readable bytes, which it leaves out by default.
+func (r *RingBuffer) SetJSONIncludeData(include bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.jsonData = include
+}
+
+// MarshalJSON implements json.Marshaler, it reports the state of the ringbuffer for debug endpoints.
+func (r *RingBuffer) MarshalJSON() ([]byte, error) {
+	r.mu.Lock()
+	state := struct {
+		Capacity int    `json:"capacity"`
+		Length   int    `json:"length"`
+		Free     int    `json:"free"`
+		Full     bool   `json:"full"`
+		Closed   bool   `json:"closed"`
+		ReadPos  int    `json:"read_pos"`
+		WritePos int    `json:"write_pos"`
+		Data     []byte `json:"data,omitempty"`
+	}{
+		Capacity: r.size,
+		Length:   r.length(),
+		Free:     r.free(),
+		Full:     r.isFull,
+		Closed:   r.closed,
+		ReadPos:  r.r,
+		WritePos: r.w,
+	}
+	if r.jsonData {
+		state.Data = make([]byte, state.Length)
+		r.peek(state.Data)
+	}
+	r.mu.Unlock()
+
+	return json.Marshal(state)
+}
+
+// SetName labels the ringbuffer, e.g. after the stream it carries, to tell buffers apart in logs.
+// Once named, String includes the name and the errors of Read, Write, ReadByte and WriteByte are wrapped
+// as "name: error", so they must be compared with errors.Is; io.EOF is never wrapped.
+// An unnamed buffer returns the bare sentinel errors.
+func (r *RingBuffer) SetName(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.name = name
+}
+
+// String implements fmt.Stringer, it reports the name and fill level of the ringbuffer.
+func (r *RingBuffer) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := "ringbuffer"
+	if r.name != "" {
+		s += " " + r.name
+	}
+	s += fmt.Sprintf(": %d/%d bytes", r.length(), r.size)
+	if r.closed {
+		s += ", closed"
+	}
+	return s
+}
+
+// wrapErr prefixes err with the name of the ringbuffer if it has one, the caller must hold r.mu.
+func (r *RingBuffer) wrapErr(err error) error {
+	if r.name == "" || err == nil || err == io.EOF {
+		return err
+	}
+	return fmt.Errorf("%s: %w", r.name, err)
+}
+
+// IsFull returns this ringbuffer is full.
+func (r *RingBuffer) IsFull() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.isFull || (r.softLimit > 0 && r.length() >= r.softLimit)
+}
+
+// IsEmpty returns this ringbuffer is empty.
+// r w 指针相遇，不为满则为空
+func (r *RingBuffer) IsEmpty() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return !r.isFull && r.w == r.r
+}
+
+// IsWrapped returns whether the readable data spans the end of the underlying buffer, i.e. is split in two segments,
+// which makes bulk operations such as Bytes or BytesNoCopy copy twice. ResetRead rebases the data to offset 0.
+// w 正好回到 0 时数据到终点为止，并没有跨越
+func (r *RingBuffer) IsWrapped() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, s2 := r.segments()
+	return len(s2) > 0
+}
+
+// Reset the read pointer and writer pointer to zero.
+// r,w 归零归零归归零。
+func (r *RingBuffer) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := r.length()
+	r.readOff += uint64(n)
+	r.r = 0
 	r.w = 0
 	r.isFull = false
+	r.stamps = nil
+	if r.opLog != nil {
+		r.logOp(OpReset, n)
+	}
+	r.signal()
+}
+
+// ResetWrite discards the bytes written but not read yet, e.g. to drop pending output.
+// Unlike Reset the read and write pointers stay where the last read left them.
+func (r *RingBuffer) ResetWrite() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := r.length()
+	r.readOff += uint64(n)
+	r.w = r.r
+	r.isFull = false
+	r.stamps = nil
+	if r.opLog != nil {
+		r.logOp(OpReset, n)
+	}
+	r.signal()
+}
+
+// ResetRead resets the read pointer to zero without losing unread data: the readable bytes are moved
+// to the start of the underlying buffer, so they become contiguous and all the free space follows them.
+// It does not allocate.
+func (r *RingBuffer) ResetRead() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.rebase()
+	if r.opLog != nil {
+		r.logOp(OpReset, r.length())
+	}
+}
+
+// Normalize is a cheaper, conditional ResetRead: it moves the readable bytes to the start of the underlying buffer only
+// when that takes a single copy. An empty buffer just has its positions reset without copying anything, a buffer already
+// starting at offset 0 is left as is, and so is one whose data wraps around the end, which would need the in-place rotation
+// of ResetRead. It is meant for the common near-empty case after processing.
+func (r *RingBuffer) Normalize() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.r == 0 {
+		return
+	}
+	if n := r.length(); n == 0 {
+		r.r, r.w = 0, 0
+	} else if n <= r.size-r.r {
+		r.rebase()
+	}
+}
+
+// rebase moves the readable bytes to offset 0 of buf, the caller must hold r.mu.
+func (r *RingBuffer) rebase() {
+	if r.r == 0 {
+		return
+	}
+	n := r.length()
+	if n <= r.size-r.r {
+		// 数据没有跨越终点，整体往前挪即可 (copy 支持重叠)
+		copy(r.buf, r.buf[r.r:r.r+n])
+	} else {
+		// 数据跨越了终点，原地把 buf 循环左移 r 位: 分别翻转 [0, r) 和 [r, size)，再整体翻转
+		reverseBytes(r.buf[:r.r])
+		reverseBytes(r.buf[r.r:])
+		reverseBytes(r.buf)
+	}
+	r.r = 0
+	r.w = r.wrap(n)
+}
+
+// ShrinkTo replaces the underlying buffer by a smaller one of newSize bytes holding the readable data at offset 0,
+// to give the memory of a large buffer back during quiet periods. It returns ErrTooManyDataToWrite
+// if more than newSize bytes are buffered, and ErrNotSupported for a buffer whose memory is released
+// by a free hook (NewWithAllocator, NewMapped). A newSize >= Capacity() leaves the buffer as is.
+// A soft limit that does not fit in newSize is removed.
+func (r *RingBuffer) ShrinkTo(newSize int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if newSize >= r.size {
+		return nil
+	}
+	if newSize < 0 || r.length() > newSize {
+		return ErrTooManyDataToWrite
+	}
+	if err := r.checkResizable(); err != nil {
+		return err
+	}
+
+	r.swapBuf(make([]byte, newSize))
+	if r.softLimit >= newSize {
+		r.softLimit = 0
+	}
+	r.signal()
+	return nil
+}
+
+// ShrinkToWithOverflow is like ShrinkTo but never refuses to shrink for lack of room: when more than newSize bytes
+// are buffered, it keeps the newest newSize bytes, i.e. the last ones written, and consumes and returns the older ones
+// as overflow, in order, so that the caller can hand them off. overflow is nil when all the data fits.
+func (r *RingBuffer) ShrinkToWithOverflow(newSize int) (overflow []byte, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if newSize >= r.size {
+		return nil, nil
+	}
+	if newSize < 0 {
+		return nil, ErrTooManyDataToWrite
+	}
+	if err = r.checkResizable(); err != nil {
+		return nil, err
+	}
+
+	if n := r.length() - newSize; n > 0 {
+		overflow = make([]byte, n)
+		r.peek(overflow)
+		r.consume(n)
+	}
+	r.swapBuf(make([]byte, newSize))
+	if r.softLimit >= newSize {
+		r.softLimit = 0
+	}
+	r.signal()
+	return overflow, nil
+}
+
+// Grow replaces the underlying buffer by a larger one of newSize bytes holding the readable data at offset 0.
+// Allocating the new buffer is done without holding the lock, readers and writers only wait for the copy
+// of the readable bytes and the swap: they see the buffer either before or after Grow, never in between,
+// and data written or read while the new buffer is being allocated is carried over.
+// A newSize <= Capacity() leaves the buffer as is, including when a concurrent Grow got there first.
+// It returns ErrNotSupported for a buffer whose memory is released by a free hook (NewWithAllocator, NewMapped).
+func (r *RingBuffer) Grow(newSize int) error {
+	r.mu.Lock()
+	size := r.size
+	err := r.checkResizable()
+	r.mu.Unlock()
+
+	if err != nil || newSize <= size {
+		return err
+	}
+	// 分配并清零一大块内存是最耗时的部分，放在锁外面做
+	buf := make([]byte, newSize)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err = r.checkResizable(); err != nil || newSize <= r.size {
+		return err
+	}
+	r.swapBuf(buf)
+	r.signal()
+	return nil
+}
+
+// checkResizable returns an error if the underlying buffer cannot be replaced, the caller must hold r.mu.
+func (r *RingBuffer) checkResizable() error {
+	if r.destroyed {
+		return ErrDestroyed
+	}
+	if r.freeHook != nil || r.mapping != nil {
+		return ErrNotSupported
+	}
+	return nil
+}
+
+// swapBuf copies the readable bytes at offset 0 of buf and makes it the underlying buffer, the caller must hold r.mu.
+func (r *RingBuffer) swapBuf(buf []byte) {
+	n := r.peek(buf)
+	r.buf = buf
+	r.size = len(buf)
+	r.pow2 = r.pow2 && r.size&(r.size-1) == 0
+	r.r = 0
+	r.w = 0
+	r.isFull = n == r.size && n > 0
+	if !r.isFull {
+		r.w = n
+	}
+}
+
+func reverseBytes(b []byte) {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+}
+
+// SetOverwrite turns the overwrite mode on or off. In overwrite mode Write and WriteByte never fail for lack of space:
+// they evict the oldest buffered bytes to make room, and Write keeps only the last Capacity() bytes of a larger p.
+func (r *RingBuffer) SetOverwrite(overwrite bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.overwrite = overwrite
+}
+
+// EvictedBytes returns how many bytes have been dropped by Write in overwrite mode so far,
+// a growing value means the consumer is falling behind.
+func (r *RingBuffer) EvictedBytes() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.evicted
+}
+
+// PauseWrites holds the write side back regardless of the available space, e.g. while an orchestrator
+// performs maintenance: non-blocking writes return ErrPaused and blocking writes wait until ResumeWrites.
+// Reads are not affected.
+func (r *RingBuffer) PauseWrites() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.paused = true
+}
+
+// ResumeWrites undoes PauseWrites and wakes up the blocked writers.
+func (r *RingBuffer) ResumeWrites() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.paused = false
+	r.signal()
+}
+
+// writesHeld reports whether writes are held back by PauseWrites or a Barrier, the caller must hold r.mu.
+func (r *RingBuffer) writesHeld() bool {
+	return r.paused || r.fences > 0
+}
+
+// SetUserData attaches v to the ringbuffer, e.g. a connection ID to recover in a stall or idle callback.
+// The ringbuffer does not use v.
+func (r *RingBuffer) SetUserData(v interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.userData = v
+}
+
+// UserData returns the value set by SetUserData, or nil.
+func (r *RingBuffer) UserData() interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.userData
+}
+
+// Close closes the ringbuffer, subsequent writes return ErrIsClosed.
+// Data already in the buffer can still be read. Close is idempotent and always returns nil.
+func (r *RingBuffer) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.close()
+	return nil
+}
+
+// close implements Close, the caller must hold r.mu.
+func (r *RingBuffer) close() {
+	if r.closed {
+		return
+	}
+	r.closed = true
+	r.signal()
+	if r.idleTimer != nil {
+		r.idleTimer.Stop()
+		r.idleTimer = nil
+	}
+	r.stopStallTimer()
+	r.stopSampler()
+	if r.fullEvents != nil {
+		close(r.fullEvents)
+	}
+}
+
+// SetIdleFlush arranges for cb to be called when no write happened for d while data is still buffered,
+// so that a batching consumer can flush without waiting for the buffer to fill up.
+// The timer is reset on each Write and WriteByte and stopped by Close. A zero d or a nil cb disables it.
+// cb is called from its own goroutine without holding the lock, so it may read from the buffer.
+func (r *RingBuffer) SetIdleFlush(d time.Duration, cb func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.idleTimer != nil {
+		r.idleTimer.Stop()
+		r.idleTimer = nil
+	}
+	if d <= 0 || cb == nil || r.closed {
+		r.idleDelay, r.idleFn = 0, nil
+		return
+	}
+
+	r.idleDelay, r.idleFn = d, cb
+	epoch := r.epoch
+	r.idleTimer = time.AfterFunc(d, func() { r.idleFire(epoch) })
+	if r.length() == 0 {
+		// 没有数据时不需要计时，等下一次写入再启动
+		r.idleTimer.Stop()
+	}
+}
+
+// resetIdleTimer restarts the idle flush timer after a write, the caller must hold r.mu.
+func (r *RingBuffer) resetIdleTimer() {
+	if r.idleTimer != nil {
+		r.idleTimer.Reset(r.idleDelay)
+	}
+}
+
+func (r *RingBuffer) idleFire(epoch uint64) {
+	r.mu.Lock()
+	fn := r.idleFn
+	buffered := r.epoch == epoch && r.idleTimer != nil && r.length() > 0
+	r.mu.Unlock()
+
+	if buffered && fn != nil {
+		fn()
+	}
 }
@@ -0,0 +1,66 @@
+// Copyright 2019 smallnest. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ringbuffer
+
+import (
+	"time"
+)
+
+// writeStamp records when n consecutive buffered bytes were written.
+type writeStamp struct {
+	n int
+	t time.Time
+}
+
+// SetAgeTracking turns on or off the tracking of how long data stays in the buffer, reported by OldestAge.
+// Times are recorded per write rather than per byte to bound the overhead; bytes already buffered
+// when tracking is turned on count as written now.
+func (r *RingBuffer) SetAgeTracking(enabled bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.trackAge = enabled
+	r.stamps = nil
+	if n := r.length(); enabled && n > 0 {
+		r.stamps = append(r.stamps, writeStamp{n: n, t: time.Now()})
+	}
+}
+
+// OldestAge returns how long the oldest readable byte has been buffered.
+// It returns 0 if the buffer is empty or age tracking is off.
+func (r *RingBuffer) OldestAge() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.stamps) == 0 {
+		return 0
+	}
+	return time.Since(r.stamps[0].t)
+}
+
+// dropStamps forgets the write times of the n oldest bytes, the caller must hold r.mu.
+func (r *RingBuffer) dropStamps(n int) {
+	for n > 0 && len(r.stamps) > 0 {
+		if s := &r.stamps[0]; s.n > n {
+			s.n -= n
+			return
+		}
+		n -= r.stamps[0].n
+		r.stamps = r.stamps[1:]
+	}
+}
+
+// dropNewestStamps forgets the write times of the n newest bytes, the caller must hold r.mu.
+func (r *RingBuffer) dropNewestStamps(n int) {
+	for n > 0 && len(r.stamps) > 0 {
+		last := &r.stamps[len(r.stamps)-1]
+		if last.n > n {
+			last.n -= n
+			return
+		}
+		n -= last.n
+		r.stamps = r.stamps[:len(r.stamps)-1]
+	}
+}
@@ -50,3 +50,78 @@ func BenchmarkRingBuffer_AsyncWrite(b *testing.B) {
 		rb.Read(buf)
 	}
 }
+
+func BenchmarkRingBuffer_SyncPow2(b *testing.B) {
+	rb := NewPow2(1024)
+	data := []byte(strings.Repeat("a", 100))
+	buf := make([]byte, 100)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rb.Write(data)
+		rb.Read(buf)
+	}
+}
+
+func BenchmarkRingBuffer_SyncMod(b *testing.B) {
+	rb := New(1024)
+	data := []byte(strings.Repeat("a", 100))
+	buf := make([]byte, 100)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rb.Write(data)
+		rb.Read(buf)
+	}
+}
+
+func BenchmarkRingBuffer_ReadBytePow2(b *testing.B) {
+	rb := NewPow2(1024)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rb.WriteByte('a')
+		rb.ReadByte()
+	}
+}
+
+func BenchmarkRingBuffer_ReadByteMod(b *testing.B) {
+	rb := New(1000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rb.WriteByte('a')
+		rb.ReadByte()
+	}
+}
+
+func BenchmarkSPSCRingBuffer_ReadByte(b *testing.B) {
+	rb := NewSPSC(1024)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rb.WriteByte('a')
+		rb.ReadByte()
+	}
+}
+
+func BenchmarkRingBuffer_NormalizeWrapped(b *testing.B) {
+	rb := New(64 * 1024)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// 200 byte 跨越终点
+		rb.r, rb.w = rb.size-100, 100
+		rb.Normalize()
+	}
+}
+
+func BenchmarkRingBuffer_ResetReadWrapped(b *testing.B) {
+	rb := New(64 * 1024)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rb.r, rb.w = rb.size-100, 100
+		rb.ResetRead()
+	}
+}
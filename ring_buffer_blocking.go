@@ -0,0 +1,615 @@
+// Copyright 2019 smallnest. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ringbuffer
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// signal wakes up all goroutines waiting on the ringbuffer and sends the fullness and non-empty notifications,
+// the caller must hold r.mu.
+// 读、写、关闭都会改变等待者关心的状态，所以统一 Broadcast，由等待者自己重新检查条件。
+func (r *RingBuffer) signal() {
+	if r.cond != nil {
+		r.cond.Broadcast()
+	}
+	r.notifyFullness()
+	r.notifyNonEmpty()
+}
+
+// watch starts a goroutine which wakes up the waiters once ctx is done,
+// so that a goroutine blocked in r.cond.Wait can notice the cancellation.
+// The returned function stops the watcher and must be called when waiting is over.
+func (r *RingBuffer) watch(ctx context.Context) (stop func()) {
+	done := ctx.Done()
+	if done == nil {
+		// context.Background() 之类永远不会被取消的 ctx，不需要 watcher
+		return func() {}
+	}
+
+	quit := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+			r.mu.Lock()
+			r.signal()
+			r.mu.Unlock()
+		case <-quit:
+		}
+	}()
+	return func() { close(quit) }
+}
+
+// waitUntil blocks until ready returns true, the ringbuffer is closed or ctx is done.
+// It returns nil when ready, ErrIsClosed when closed (ErrDestroyed when destroyed) and ctx.Err() when ctx is done.
+// The caller must hold r.mu, ready is always evaluated with r.mu held.
+//
+// The cond and the watcher goroutine are only created once a caller actually has to wait, so a buffer
+// used only through the non-blocking methods carries no blocking machinery; the first wait pays a small
+// one-time allocation for the cond.
+func (r *RingBuffer) waitUntil(ctx context.Context, ready func() bool) error {
+	var stop func()
+	defer func() {
+		if stop != nil {
+			stop()
+		}
+	}()
+
+	for !ready() {
+		if r.destroyed {
+			return ErrDestroyed
+		}
+		if r.closed {
+			return ErrIsClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if r.cond == nil {
+			r.cond = sync.NewCond(&r.mu)
+		}
+		if stop == nil {
+			stop = r.watch(ctx)
+		}
+		r.cond.Wait()
+	}
+	return nil
+}
+
+// ReadBlocking reads up to len(p) bytes into p, blocking until some data is available.
+// It returns ErrIsClosed if the ringbuffer is closed and drained.
+func (r *RingBuffer) ReadBlocking(p []byte) (n int, err error) {
+	return r.ReadContext(context.Background(), p)
+}
+
+// ReadContext reads up to len(p) bytes into p, blocking until some data is available.
+// It returns ErrIsClosed if the ringbuffer is closed and drained, or ctx.Err() if ctx is done first.
+func (r *RingBuffer) ReadContext(ctx context.Context, p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	leave, err := r.enterQueue(ctx, &r.readers)
+	if err != nil {
+		return 0, err
+	}
+	defer leave()
+
+	if r.size == 0 {
+		return r.takeHandoff(ctx, p)
+	}
+	err = r.waitUntil(ctx, func() bool { return r.w != r.r || r.isFull })
+	if err != nil {
+		return 0, err
+	}
+	return r.read(p)
+}
+
+// ReadByteContext reads and returns the next byte, blocking until one is available.
+// It returns ErrIsClosed if the ringbuffer is closed and drained, or ctx.Err() if ctx is done first.
+func (r *RingBuffer) ReadByteContext(ctx context.Context) (b byte, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	leave, err := r.enterQueue(ctx, &r.readers)
+	if err != nil {
+		return 0, err
+	}
+	defer leave()
+
+	if r.size == 0 {
+		var p [1]byte
+		_, err = r.takeHandoff(ctx, p[:])
+		return p[0], err
+	}
+	err = r.waitUntil(ctx, func() bool { return r.w != r.r || r.isFull })
+	if err != nil {
+		return 0, err
+	}
+	return r.readByte(), nil
+}
+
+// ReadFullBlocking reads exactly len(p) bytes into p, blocking until enough data has been written.
+// When len(p) fits in the usable capacity (the soft limit if set, less the space held by ReserveFrame)
+// it waits for all of it and reads it at once, otherwise it reads as data arrives.
+// If the ringbuffer is closed first, it reads what is left and returns io.EOF if nothing was read
+// or io.ErrUnexpectedEOF after a partial read.
+func (r *RingBuffer) ReadFullBlocking(p []byte) (n int, err error) {
+	return r.ReadFullContext(context.Background(), p)
+}
+
+// ReadFullContext is like ReadFullBlocking but gives up once ctx is done, returning ctx.Err() and the number
+// of bytes read so far, which tells a cancellation apart from a clean close (io.EOF or io.ErrUnexpectedEOF).
+func (r *RingBuffer) ReadFullContext(ctx context.Context, p []byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	leave, err := r.enterQueue(ctx, &r.readers)
+	if err != nil {
+		return 0, err
+	}
+	defer leave()
+
+	for n < len(p) {
+		var c int
+		if r.size == 0 {
+			c, err = r.takeHandoff(ctx, p[n:])
+		} else {
+			want := len(p) - n
+			err = r.waitUntil(ctx, func() bool {
+				// 不能超过当前能存下的数据量: soft limit 和 ReserveFrame 占用的空间都会让它小于 size
+				limit := r.capacity() - r.reserved
+				if limit < 1 {
+					limit = 1
+				}
+				if want > limit {
+					return r.length() >= limit
+				}
+				return r.length() >= want
+			})
+			if err == nil || err == ErrIsClosed {
+				c, _ = r.read(p[n:])
+			}
+		}
+		n += c
+
+		if err == ErrIsClosed {
+			if n == 0 {
+				return 0, io.EOF
+			}
+			if n < len(p) {
+				return n, io.ErrUnexpectedEOF
+			}
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// WriteBlocking writes all of p, blocking while the buffer is full.
+// It returns ErrIsClosed with the number of bytes written if the ringbuffer is closed before p is fully written.
+func (r *RingBuffer) WriteBlocking(p []byte) (n int, err error) {
+	return r.WriteContext(context.Background(), p)
+}
+
+// WriteContext writes all of p, blocking while the buffer is full or writes are paused, and paced by the write rate limit if one is set.
+// On a zero size buffer it hands p directly to the goroutines blocked in ReadBlocking, ReadContext or ReadByteContext,
+// and returns once all of p has been taken, like a send on an unbuffered channel.
+// It stops with the number of bytes written and ctx.Err() if ctx is done, or ErrIsClosed if the ringbuffer is closed.
+// A large p may be written in several pieces, interleaved with other writers.
+func (r *RingBuffer) WriteContext(ctx context.Context, p []byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	leave, err := r.enterQueue(ctx, &r.writers)
+	if err != nil {
+		return 0, err
+	}
+	defer leave()
+
+	if r.size == 0 && len(p) > 0 {
+		if err = r.waitUntil(ctx, func() bool { return !r.writesHeld() }); err != nil {
+			return 0, err
+		}
+		return r.giveHandoff(ctx, p)
+	}
+	for n < len(p) {
+		// 覆盖模式下 write 会淘汰最旧的数据，buffer 满了也不用等
+		if err = r.waitUntil(ctx, func() bool { return !r.writesHeld() && r.writable() }); err != nil {
+			return n, err
+		}
+
+		chunk := p[n:]
+		if r.limiter != nil {
+			allowed, wait := r.limiter.take(len(chunk), time.Now())
+			if allowed == 0 {
+				if err = r.sleep(ctx, wait); err != nil {
+					return n, err
+				}
+				continue
+			}
+			chunk = chunk[:allowed]
+		}
+
+		var c int
+		c, err = r.write(chunk)
+		if r.limiter != nil {
+			r.limiter.giveBack(len(chunk) - c)
+		}
+		n += c
+		if err != nil && err != ErrTooManyDataToWrite {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// WriteTimeoutRemainder is like WriteContext with a timeout of d, for a best-effort producer with a latency bound:
+// it writes as much of p as fits within d and returns the unwritten tail of p as remainder, a view into p,
+// so the caller can retry or drop it. err is context.DeadlineExceeded if d elapsed first, remainder is nil when all of p was written.
+func (r *RingBuffer) WriteTimeoutRemainder(p []byte, d time.Duration) (written int, remainder []byte, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	written, err = r.WriteContext(ctx, p)
+	if written < len(p) {
+		remainder = p[written:]
+	}
+	return written, remainder, err
+}
+
+// sleep releases r.mu for d or until ctx is done, the caller must hold r.mu.
+func (r *RingBuffer) sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	r.mu.Unlock()
+	defer r.mu.Lock()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// A zero size ringbuffer cannot hold any data, in blocking mode it is a rendezvous point instead:
+// a blocked writer publishes its bytes in r.handoff and blocking readers copy them out directly.
+// Read and Write take part in the handoff once SetBlocking is on, otherwise they keep returning ErrIsEmpty and ErrIsFull.
+
+// giveHandoff hands p to the readers of a zero size ringbuffer, the caller must hold r.mu.
+func (r *RingBuffer) giveHandoff(ctx context.Context, p []byte) (n int, err error) {
+	// 同一时间只允许一个 writer 交接数据
+	if err = r.waitUntil(ctx, func() bool { return !r.handing }); err != nil {
+		return 0, err
+	}
+	r.handing = true
+	r.handoff = p
+	r.signal()
+
+	err = r.waitUntil(ctx, func() bool { return len(r.handoff) == 0 })
+	n = len(p) - len(r.handoff)
+	r.handing = false
+	r.handoff = nil
+	r.signal()
+	return n, err
+}
+
+// takeHandoff copies bytes published by the writer of a zero size ringbuffer into p, the caller must hold r.mu.
+func (r *RingBuffer) takeHandoff(ctx context.Context, p []byte) (n int, err error) {
+	if err = r.waitUntil(ctx, func() bool { return len(r.handoff) > 0 }); err != nil {
+		return 0, err
+	}
+	n = copy(p, r.handoff)
+	if r.writeHash != nil {
+		r.writeHash.Write(p[:n])
+	}
+	if r.readHash != nil {
+		r.readHash.Write(p[:n])
+	}
+	r.handoff = r.handoff[n:]
+	r.written += uint64(n)
+	r.readOff += uint64(n)
+	r.signal()
+	return n, nil
+}
+
+// WaitFree blocks until at least n bytes can be written, so that a producer can prepare a batch
+// only once it knows it will fit. It returns ErrTooManyDataToWrite right away if n exceeds the capacity
+// (or the soft limit), ErrIsClosed if the ringbuffer is closed and ctx.Err() if ctx is done first.
+// Another writer may still take the space before the caller writes.
+func (r *RingBuffer) WaitFree(n int, ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if n > r.capacity() {
+		return ErrTooManyDataToWrite
+	}
+	return r.waitUntil(ctx, func() bool { return r.free() >= n })
+}
+
+// SetBlocking switches the default behavior of Read and Write at runtime. In blocking mode Read waits
+// until some data is available and Write until all of p is written, like ReadBlocking and WriteBlocking,
+// instead of returning ErrIsEmpty and ErrIsFull, and Read returns io.EOF once the ringbuffer is closed and drained.
+// Switching back to non-blocking wakes up the callers waiting in Read and Write,
+// which then return what they got with the usual non-blocking errors.
+// The other methods, e.g. ReadByte and WriteByte, are not affected.
+func (r *RingBuffer) SetBlocking(blocking bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.block = blocking
+	r.signal()
+}
+
+// SetMinRead sets a low watermark for Read, like SO_RCVLOWAT: in blocking mode Read waits until at least n bytes
+// (or len(p) if smaller) are buffered before returning, to save the per call cost of tiny reads.
+// In non-blocking mode Read returns ErrIsEmpty while fewer bytes are buffered.
+// A full buffer, or a closed one, satisfies any watermark, so Read still returns the remaining bytes after Close.
+// A n <= 1 restores the default behavior. The other read methods ignore it.
+func (r *RingBuffer) SetMinRead(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if n < 0 {
+		n = 0
+	}
+	r.minRead = n
+	r.signal()
+}
+
+// waitBlockingMode waits until ready returns true, the ringbuffer is closed or blocking mode is turned off,
+// the caller must hold r.mu.
+func (r *RingBuffer) waitBlockingMode(ready func() bool) {
+	r.waitUntil(context.Background(), func() bool { return !r.block || ready() })
+}
+
+// writeBlockingMode implements Write in blocking mode, the caller must hold r.mu.
+func (r *RingBuffer) writeBlockingMode(p []byte) (n int, err error) {
+	if r.size == 0 {
+		return r.giveHandoffBlockingMode(p)
+	}
+	for n < len(p) {
+		r.waitBlockingMode(func() bool { return !r.writesHeld() && r.writable() })
+
+		var c int
+		c, err = r.write(p[n:])
+		n += c
+		if !r.block || (err != nil && err != ErrTooManyDataToWrite) {
+			// 切换回非阻塞模式后按照 Write 的语义返回
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Offer writes p with a growth and backpressure policy in a single call, for producers of an unbounded stream
+// that want a buffer as small as possible but never want to lose data. What does not fit is handled in this order:
+//  1. grow: if the size is below maxGrow, the buffer is replaced by one of twice the size, or as large as needed for p,
+//     but at most maxGrow bytes, and the rest of p is written;
+//  2. block: if p still does not fit and block is true, Offer waits for readers to make room until all of p is written;
+//  3. otherwise it returns the short count with ErrTooManyDataToWrite, or ErrIsFull if nothing was written.
+//
+// Unlike Grow, the new buffer is allocated with the lock held so that the write and the growth are atomic.
+// A buffer whose memory is released by a free hook (NewWithAllocator, NewMapped) or capped by a soft limit never grows.
+// It returns ErrIsClosed if the ringbuffer is closed while waiting.
+func (r *RingBuffer) Offer(p []byte, maxGrow int, block bool) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, err = r.write(p)
+	if err != ErrIsFull && err != ErrTooManyDataToWrite {
+		return n, err
+	}
+	// 先扩容，扩到 maxGrow 还放不下再阻塞; 受 soft limit 限制时扩容也没用
+	if r.softLimit == 0 && r.capacity() < maxGrow && r.checkResizable() == nil {
+		newSize := maxGrow
+		if r.size <= maxGrow/2 {
+			newSize = 2 * r.size
+		}
+		if need := r.length() + len(p) - n; newSize < need {
+			newSize = need
+		}
+		if newSize > maxGrow {
+			newSize = maxGrow
+		}
+		r.swapBuf(make([]byte, newSize))
+
+		var c int
+		c, err = r.write(p[n:])
+		n += c
+	}
+	for block && (err == ErrIsFull || err == ErrTooManyDataToWrite) {
+		if err = r.waitUntil(context.Background(), func() bool { return !r.writesHeld() && r.free() > 0 }); err != nil {
+			return n, err
+		}
+		var c int
+		c, err = r.write(p[n:])
+		n += c
+	}
+	if n > 0 && err == ErrIsFull {
+		err = ErrTooManyDataToWrite
+	}
+	return n, err
+}
+
+// giveHandoffBlockingMode implements Write in blocking mode on a zero size ringbuffer: it hands p to the readers
+// like WriteBlocking, and stops with the usual non-blocking errors if the ringbuffer is closed or blocking mode
+// is turned off first, the caller must hold r.mu.
+func (r *RingBuffer) giveHandoffBlockingMode(p []byte) (n int, err error) {
+	r.waitBlockingMode(func() bool { return !r.writesHeld() && !r.handing })
+	if r.block && !r.closed && !r.writesHeld() && !r.handing {
+		r.handing = true
+		r.handoff = p
+		r.signal()
+
+		r.waitBlockingMode(func() bool { return len(r.handoff) == 0 })
+		n = len(p) - len(r.handoff)
+		r.handing = false
+		r.handoff = nil
+		r.signal()
+		if n == len(p) {
+			return n, nil
+		}
+	}
+	// 没交接完: write 在零长度的 buffer 上没法写入，只会给出对应的错误
+	_, err = r.write(p[n:])
+	if n > 0 && err == ErrIsFull {
+		err = ErrTooManyDataToWrite
+	}
+	return n, err
+}
+
+// writable reports whether a write can make progress: there is free space, or the oldest data can be evicted
+// in overwrite mode, the caller must hold r.mu.
+func (r *RingBuffer) writable() bool {
+	return r.free() > 0 || (r.overwrite && r.capacity()-r.reserved > 0)
+}
+
+// takeHandoffBlockingMode implements Read in blocking mode on a zero size ringbuffer: it waits for a writer
+// to hand bytes off like ReadBlocking. It reports false without reading anything if the ringbuffer is closed
+// or blocking mode is turned off first, the caller must hold r.mu.
+func (r *RingBuffer) takeHandoffBlockingMode(p []byte) (n int, ok bool) {
+	r.waitBlockingMode(func() bool { return len(r.handoff) > 0 })
+	if len(r.handoff) == 0 {
+		return 0, false
+	}
+	n, _ = r.takeHandoff(context.Background(), p)
+	return n, true
+}
+
+// Barrier waits until readers have consumed every byte written before the call, holding new writes back meanwhile,
+// e.g. to take a snapshot once all the data up to here has been processed. While a Barrier is pending,
+// blocking writes wait and non-blocking writes return ErrPaused, as with PauseWrites; a blocking write already
+// in progress is stopped between two pieces, so only the part written before the call is covered by the barrier.
+// Reads are not affected. When Barrier returns, writes resume unless they are paused or another Barrier is pending.
+// It returns ErrIsClosed if the ringbuffer is closed before the data is consumed, or ctx.Err() if ctx is done first.
+func (r *RingBuffer) Barrier(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	target := r.written
+	r.fences++
+	err := r.waitUntil(ctx, func() bool { return r.readOff >= target })
+	r.fences--
+	r.signal()
+	return err
+}
+
+// ReadLineIdleTimeout is like ReadLine but waits for a full line, giving up only on silence: it returns
+// context.DeadlineExceeded if no byte is written for gap, the timer being restarted by every write, like the inter-byte
+// timeout of a serial line. On timeout the partial line is left in the buffer. It returns ErrIsClosed if the ringbuffer
+// is closed without a full line buffered.
+func (r *RingBuffer) ReadLineIdleTimeout(gap time.Duration) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for {
+		line, err := r.readLine()
+		if err != ErrIsEmpty {
+			return line, err
+		}
+
+		last := r.written
+		ctx, cancel := context.WithTimeout(context.Background(), gap)
+		err = r.waitUntil(ctx, func() bool { return r.written != last })
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ReadCloser returns the read end of the ringbuffer as an io.ReadCloser, for APIs which own and close their reader,
+// e.g. an http request body. Its Read blocks until some data is available and returns io.EOF once the ringbuffer
+// is closed and drained, its Close closes the ringbuffer.
+func (r *RingBuffer) ReadCloser() io.ReadCloser {
+	return readCloser{r}
+}
+
+type readCloser struct {
+	rb *RingBuffer
+}
+
+func (rc readCloser) Read(p []byte) (int, error) {
+	n, err := rc.rb.ReadBlocking(p)
+	if err == ErrIsClosed {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (rc readCloser) Close() error {
+	return rc.rb.Close()
+}
+
+// SetFair turns on or off the FIFO-fair mode of the blocking methods. By default the goroutines blocked
+// in WriteBlocking or WriteContext race for the lock each time space is freed, so a large writer can keep losing
+// against a stream of small ones. In fair mode blocking writers take a ticket and are served one at a time in
+// arrival order, each one writing all of its p before the next one starts, and the blocking readers
+// (ReadBlocking, ReadContext, ReadByteContext, ReadFullBlocking) are served in arrival order the same way.
+// The non-blocking methods do not queue.
+func (r *RingBuffer) SetFair(fair bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.fair = fair
+	r.signal()
+}
+
+// ticketQueue serves blocked goroutines in arrival order, protected by r.mu.
+type ticketQueue struct {
+	next    uint64          // ticket of the next goroutine to queue
+	serving uint64          // ticket of the goroutine being served
+	gone    map[uint64]bool // tickets given up before their turn, e.g. on a done ctx
+}
+
+// done releases ticket t, whether it was served or not.
+func (q *ticketQueue) done(t uint64) {
+	if t != q.serving {
+		// 还没轮到就放弃了，轮到它的时候直接跳过
+		if q.gone == nil {
+			q.gone = make(map[uint64]bool)
+		}
+		q.gone[t] = true
+		return
+	}
+	q.serving++
+	for q.gone[q.serving] {
+		delete(q.gone, q.serving)
+		q.serving++
+	}
+}
+
+// enterQueue waits for the turn of the caller in q when the fair mode is on, the caller must hold r.mu.
+// The returned leave must be called with r.mu held once the caller is done, to serve the next goroutine.
+func (r *RingBuffer) enterQueue(ctx context.Context, q *ticketQueue) (leave func(), err error) {
+	if !r.fair {
+		return func() {}, nil
+	}
+	t := q.next
+	q.next++
+	leave = func() {
+		q.done(t)
+		r.signal()
+	}
+	// 等待期间关掉了 fair 模式或者关闭了 buffer 就不再排队，关闭之后剩下的数据谁先读到都行
+	if err = r.waitUntil(ctx, func() bool { return !r.fair || r.closed || q.serving == t }); err != nil {
+		leave()
+		return nil, err
+	}
+	return leave, nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2019 smallnest. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ringbuffer
+
+import (
+	"sync"
+	"time"
+)
+
+// CoalescingWriter stages small writes and makes them visible to the readers of a RingBuffer in batches,
+// once threshold bytes are staged or at least every interval, so that chatty producers cause fewer wakeups.
+// Flushes block while the ringbuffer is full. It is safe for concurrent use.
+type CoalescingWriter struct {
+	rb        *RingBuffer
+	threshold int
+
+	mu      sync.Mutex
+	staging []byte
+	stop    chan struct{}
+	done    chan struct{}
+	closed  bool
+}
+
+// NewCoalescingWriter returns a CoalescingWriter writing into rb, with a background goroutine
+// flushing every interval until Close. An interval <= 0 disables the timed flush: staged bytes are then
+// only flushed once threshold bytes are staged, by Flush or by Close. A threshold <= 0 flushes on every write.
+func NewCoalescingWriter(rb *RingBuffer, threshold int, interval time.Duration) *CoalescingWriter {
+	if threshold < 0 {
+		threshold = 0
+	}
+	cw := &CoalescingWriter{
+		rb:        rb,
+		threshold: threshold,
+		staging:   make([]byte, 0, threshold),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if interval > 0 {
+		go cw.flusher(interval)
+	} else {
+		close(cw.done)
+	}
+	return cw
+}
+
+// Write stages p and flushes if threshold bytes are staged.
+func (cw *CoalescingWriter) Write(p []byte) (int, error) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	if cw.closed {
+		return 0, ErrIsClosed
+	}
+	cw.staging = append(cw.staging, p...)
+	if len(cw.staging) >= cw.threshold {
+		if err := cw.flush(); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// WriteByte stages c and flushes if threshold bytes are staged.
+func (cw *CoalescingWriter) WriteByte(c byte) error {
+	_, err := cw.Write([]byte{c})
+	return err
+}
+
+// Flush writes the staged bytes into the ringbuffer, blocking while it is full.
+func (cw *CoalescingWriter) Flush() error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	return cw.flush()
+}
+
+// flush writes the staged bytes, the caller must hold cw.mu. Bytes which could not be written stay staged.
+func (cw *CoalescingWriter) flush() error {
+	if len(cw.staging) == 0 {
+		return nil
+	}
+	n, err := cw.rb.WriteBlocking(cw.staging)
+	cw.staging = cw.staging[:copy(cw.staging, cw.staging[n:])]
+	return err
+}
+
+// Close stops the background flusher and flushes the staged bytes.
+// It does not close the underlying ringbuffer.
+func (cw *CoalescingWriter) Close() error {
+	cw.mu.Lock()
+	if cw.closed {
+		cw.mu.Unlock()
+		return nil
+	}
+	cw.closed = true
+	cw.mu.Unlock()
+
+	close(cw.stop)
+	<-cw.done
+	return cw.Flush()
+}
+
+func (cw *CoalescingWriter) flusher(interval time.Duration) {
+	defer close(cw.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cw.Flush()
+		case <-cw.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2019 smallnest. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ringbuffer
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// NewConnPipe returns the two ends of an in-memory connection, like net.Pipe but buffered: each direction is
+// a ringbuffer of the given size, so a Write only blocks once the peer has size unread bytes, and the read
+// and write deadlines work as for a real connection, including on pending calls.
+// A call stopped by a deadline returns os.ErrDeadlineExceeded, which is a timeout net.Error.
+// After Close, the peer reads the remaining bytes and then io.EOF, while calls on the closed end
+// and writes of the peer return io.ErrClosedPipe.
+func NewConnPipe(size int) (net.Conn, net.Conn) {
+	a, b := New(size), New(size)
+	return newPipeConn(a, b), newPipeConn(b, a)
+}
+
+// pipeConn is an end of a NewConnPipe connection, it reads from rd and writes to wr.
+type pipeConn struct {
+	rd, wr *RingBuffer
+
+	mu            sync.Mutex
+	closed        bool
+	readDeadline  pipeDeadline
+	writeDeadline pipeDeadline
+}
+
+// pipeDeadline is the current deadline of one direction of a pipeConn. Setting a new one cancels ctx,
+// so the pending calls wake up and go on with the new deadline.
+type pipeDeadline struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newPipeConn(rd, wr *RingBuffer) *pipeConn {
+	c := &pipeConn{rd: rd, wr: wr}
+	c.readDeadline.set(time.Time{})
+	c.writeDeadline.set(time.Time{})
+	return c
+}
+
+// set replaces the deadline by t, a zero t means none, the caller must hold the lock of the pipeConn.
+func (d *pipeDeadline) set(t time.Time) {
+	if d.cancel != nil {
+		d.cancel()
+	}
+	if t.IsZero() {
+		d.ctx, d.cancel = context.WithCancel(context.Background())
+	} else {
+		d.ctx, d.cancel = context.WithDeadline(context.Background(), t)
+	}
+}
+
+// deadlineCtx returns the context carrying the current deadline of d.
+func (c *pipeConn) deadlineCtx(d *pipeDeadline) context.Context {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return d.ctx
+}
+
+func (c *pipeConn) Read(p []byte) (int, error) {
+	for {
+		if c.isClosed() {
+			return 0, io.ErrClosedPipe
+		}
+		ctx := c.deadlineCtx(&c.readDeadline)
+		if ctx.Err() == context.DeadlineExceeded {
+			// deadline 已过，即使有数据也不再读
+			return 0, os.ErrDeadlineExceeded
+		}
+		n, err := c.rd.ReadContext(ctx, p)
+		switch err {
+		case context.Canceled:
+			// 设置了新的 deadline，按新的 deadline 继续等
+			continue
+		case context.DeadlineExceeded:
+			return n, os.ErrDeadlineExceeded
+		case ErrIsClosed, ErrDestroyed:
+			if c.isClosed() {
+				return n, io.ErrClosedPipe
+			}
+			return n, io.EOF
+		}
+		return n, err
+	}
+}
+
+func (c *pipeConn) Write(p []byte) (int, error) {
+	var n int
+	for {
+		if c.isClosed() {
+			return n, io.ErrClosedPipe
+		}
+		ctx := c.deadlineCtx(&c.writeDeadline)
+		if ctx.Err() == context.DeadlineExceeded {
+			return n, os.ErrDeadlineExceeded
+		}
+		w, err := c.wr.WriteContext(ctx, p[n:])
+		n += w
+		switch err {
+		case context.Canceled:
+			continue
+		case context.DeadlineExceeded:
+			return n, os.ErrDeadlineExceeded
+		case ErrIsClosed, ErrDestroyed:
+			return n, io.ErrClosedPipe
+		}
+		return n, err
+	}
+}
+
+func (c *pipeConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed
+}
+
+// Close closes both directions: the peer can still read what was written before.
+func (c *pipeConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+
+	c.rd.Close()
+	c.wr.Close()
+	return nil
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return pipeAddr{} }
+func (c *pipeConn) RemoteAddr() net.Addr { return pipeAddr{} }
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *pipeConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// pipeAddr is the address of both ends of a NewConnPipe connection.
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "ringbuffer" }
+func (pipeAddr) String() string  { return "ringbuffer" }
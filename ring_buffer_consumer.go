@@ -0,0 +1,71 @@
+// Copyright 2019 smallnest. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ringbuffer
+
+import (
+	"context"
+	"io"
+)
+
+// Consumer drains a RingBuffer chunk by chunk, see NewConsumer.
+type Consumer struct {
+	rb     *RingBuffer
+	closed bool // protected by rb.mu
+}
+
+// NewConsumer returns a Consumer reading successive chunks of r, which wraps the usual
+// blocking read loop of a draining goroutine:
+//
+//	c := rb.NewConsumer()
+//	for {
+//		chunk, err := c.Next(4096)
+//		if err != nil {
+//			break // io.EOF once rb is closed and drained
+//		}
+//		process(chunk)
+//	}
+func (r *RingBuffer) NewConsumer() *Consumer {
+	return &Consumer{rb: r}
+}
+
+// Next returns a fresh copy of up to max readable bytes, blocking while the ringbuffer is empty. A max <= 0 means no cap.
+// It returns io.EOF once the ringbuffer is closed and drained, ErrIsClosed if the Consumer is closed
+// and ErrDestroyed if the ringbuffer is destroyed.
+func (c *Consumer) Next(max int) ([]byte, error) {
+	r := c.rb
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := r.waitUntil(context.Background(), func() bool { return c.closed || r.w != r.r || r.isFull })
+	if c.closed {
+		return nil, ErrIsClosed
+	}
+	if err == ErrIsClosed {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	n := r.length()
+	if max > 0 && n > max {
+		n = max
+	}
+	p := make([]byte, n)
+	if _, err = r.read(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Close stops the Consumer and wakes up a blocked Next, the ringbuffer itself stays open.
+func (c *Consumer) Close() error {
+	c.rb.mu.Lock()
+	defer c.rb.mu.Unlock()
+
+	c.closed = true
+	c.rb.signal()
+	return nil
+}
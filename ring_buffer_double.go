@@ -0,0 +1,55 @@
+// Copyright 2019 smallnest. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ringbuffer
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrNotDrained is returned by Flip when the standby buffer still holds unread bytes.
+var ErrNotDrained = errors.New("standby ringbuffer is not drained")
+
+// DoubleBuffer is a pair of ringbuffers sharing one allocation for double buffering: producers fill the active
+// buffer while a consumer drains the other one, then Flip swaps them, see NewDoubleBuffer.
+type DoubleBuffer struct {
+	mu      sync.Mutex
+	active  *RingBuffer
+	standby *RingBuffer
+}
+
+// NewDoubleBuffer returns a DoubleBuffer made of two ringbuffers of halfSize bytes each, backed by
+// a single allocation of 2*halfSize bytes.
+func NewDoubleBuffer(halfSize int) *DoubleBuffer {
+	buf := make([]byte, 2*halfSize)
+	return &DoubleBuffer{
+		active:  newWithBuf(buf[:halfSize:halfSize]),
+		standby: newWithBuf(buf[halfSize:]),
+	}
+}
+
+// Active returns the buffer producers should write to. It changes on Flip, so producers should
+// call Active again after each Flip instead of keeping the result.
+func (d *DoubleBuffer) Active() *RingBuffer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.active
+}
+
+// Flip swaps the active and standby buffers and returns the buffer that was just filled, which is now the standby one,
+// for the consumer to drain. The standby buffer must have been drained before: otherwise Flip swaps nothing and
+// returns ErrNotDrained, so a slow consumer never loses data and the producers keep filling the current active buffer.
+// A write in progress on the former active buffer while Flip runs ends up in the returned buffer.
+func (d *DoubleBuffer) Flip() (*RingBuffer, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.standby.IsEmpty() {
+		return nil, ErrNotDrained
+	}
+	d.active, d.standby = d.standby, d.active
+	return d.standby, nil
+}
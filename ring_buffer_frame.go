@@ -0,0 +1,360 @@
+// Copyright 2019 smallnest. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ringbuffer
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+var (
+	// ErrFrameTooLarge is returned when a frame length exceeds the maximum frame size or what the buffer can hold.
+	ErrFrameTooLarge = errors.New("frame too large")
+	// ErrCRCMismatch is returned by ReadFrameCRC when the checksum of a frame does not match its payload.
+	ErrCRCMismatch = errors.New("frame crc mismatch")
+	// ErrFrameReserved is returned by ReserveFrame when the reservation of another frame is pending.
+	ErrFrameReserved = errors.New("a frame is already reserved")
+	// ErrNoFrameReserved is returned by WriteReserved and CommitFrame without a pending reservation.
+	ErrNoFrameReserved = errors.New("no frame is reserved")
+)
+
+// A frame is a payload preceded by its length, encoded as an unsigned integer of prefixBytes bytes
+// (1, 2, 4 or 8) in big or little endian order.
+
+// SetMaxFrameSize caps the payload length ReadFrame accepts at n bytes, a n <= 0 removes the cap.
+// A length prefix declaring a larger frame, e.g. from a corrupt or malicious stream, makes ReadFrame return
+// ErrFrameTooLarge without allocating anything.
+func (r *RingBuffer) SetMaxFrameSize(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if n < 0 {
+		n = 0
+	}
+	r.maxFrame = n
+}
+
+// WriteFrame writes payload as one frame with a length prefix of prefixBytes bytes, all at once or not at all.
+// It returns ErrFrameTooLarge if the length does not fit in the prefix, ErrTooManyDataToWrite if the frame
+// is larger than the capacity, ErrIsFull if there is not enough free space right now and ErrQuotaExceeded
+// if the frame would exceed the write quota.
+func (r *RingBuffer) WriteFrame(prefixBytes int, bigEndian bool, payload []byte) error {
+	checkFramePrefix(prefixBytes)
+	var hdr [8]byte
+	if !putFrameLen(hdr[:prefixBytes], bigEndian, len(payload)) {
+		return ErrFrameTooLarge
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkFrameWrite(prefixBytes+len(payload), r.free()); err != nil {
+		return err
+	}
+	if _, err := r.write(hdr[:prefixBytes]); err != nil {
+		return err
+	}
+	_, err := r.write(payload)
+	return err
+}
+
+// ReadFrame reads one frame with a length prefix of prefixBytes bytes and returns a copy of its payload.
+// If the frame is not fully buffered yet, nothing is consumed and ErrIsEmpty is returned.
+// If the declared length exceeds the maximum frame size (see SetMaxFrameSize) or could never fit in the buffer,
+// nothing is consumed and ErrFrameTooLarge is returned, the caller can then Reset the buffer or skip bytes to resync.
+func (r *RingBuffer) ReadFrame(prefixBytes int, bigEndian bool) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.readFrame(prefixBytes, bigEndian)
+}
+
+// ReadAllFrames reads up to max complete frames under a single lock acquisition and returns copies of their payloads,
+// leaving a trailing partial frame buffered, for consumers handling frames in batches. A max <= 0 means no cap.
+// It returns ErrIsEmpty if no complete frame is buffered. A declared length that is not acceptable, as for ReadFrame,
+// stops the batch: the frames before it are returned along with ErrFrameTooLarge and the bad frame is not consumed.
+func (r *RingBuffer) ReadAllFrames(prefixBytes int, bigEndian bool, max int) ([][]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.destroyed {
+		return nil, ErrDestroyed
+	}
+	var frames [][]byte
+	for max <= 0 || len(frames) < max {
+		p, err := r.readFrame(prefixBytes, bigEndian)
+		if err == ErrFrameTooLarge {
+			return frames, err
+		}
+		if err != nil {
+			break
+		}
+		frames = append(frames, p)
+	}
+	if len(frames) == 0 {
+		return nil, ErrIsEmpty
+	}
+	return frames, nil
+}
+
+// readFrame reads one frame with the same semantics as ReadFrame, the caller must hold r.mu.
+func (r *RingBuffer) readFrame(prefixBytes int, bigEndian bool) ([]byte, error) {
+	n, err := r.frameLen(prefixBytes, bigEndian)
+	if err != nil {
+		return nil, err
+	}
+	if r.length() < prefixBytes+n {
+		return nil, ErrIsEmpty
+	}
+	r.consume(prefixBytes)
+	p := make([]byte, n)
+	r.peek(p)
+	r.consume(n)
+	return p, nil
+}
+
+// crcFramePrefix is the size of the big endian length prefix of the frames of WriteFrameCRC,
+// followed by the payload and its CRC-32 (IEEE) as 4 big endian bytes.
+const crcFramePrefix = 4
+
+// WriteFrameCRC writes payload as one integrity checked frame: a 4 byte big endian length, the payload
+// and its CRC-32 (IEEE), all at once or not at all, with the same errors as WriteFrame.
+func (r *RingBuffer) WriteFrameCRC(payload []byte) error {
+	var hdr, trailer [4]byte
+	if !putFrameLen(hdr[:], true, len(payload)) {
+		return ErrFrameTooLarge
+	}
+	binary.BigEndian.PutUint32(trailer[:], crc32.ChecksumIEEE(payload))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkFrameWrite(len(hdr)+len(payload)+len(trailer), r.free()); err != nil {
+		return err
+	}
+	// 持有锁期间分三次写入，读者看不到写了一半的 frame
+	for _, p := range [][]byte{hdr[:], payload, trailer[:]} {
+		if _, err := r.write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadFrameCRC reads one frame written by WriteFrameCRC and returns a copy of its payload.
+// Like ReadFrame, it returns ErrIsEmpty without consuming anything if the frame is not fully buffered yet,
+// and ErrFrameTooLarge if its length is not acceptable. A frame whose checksum does not match
+// is consumed and ErrCRCMismatch is returned.
+func (r *RingBuffer) ReadFrameCRC() ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, err := r.frameLen(crcFramePrefix, true)
+	if err != nil {
+		return nil, err
+	}
+	if n > r.capacity()-crcFramePrefix-4 {
+		return nil, ErrFrameTooLarge
+	}
+	if r.length() < crcFramePrefix+n+4 {
+		return nil, ErrIsEmpty
+	}
+	r.consume(crcFramePrefix)
+	p := make([]byte, n+4)
+	r.peek(p)
+	r.consume(len(p))
+
+	if binary.BigEndian.Uint32(p[n:]) != crc32.ChecksumIEEE(p[:n]) {
+		return nil, ErrCRCMismatch
+	}
+	return p[:n:n], nil
+}
+
+// ReserveFrame holds size bytes of free space back for a frame that is then assembled piece by piece
+// with WriteReserved, e.g. a header and the pieces of a body, and written all at once by CommitFrame.
+// Other writers see the reserved space as taken, so it cannot be stolen, and since the pieces are only
+// copied into the buffer on CommitFrame they can't be interleaved with their writes. Readers never see a partial frame.
+// It returns false if size bytes are not free right now, ErrTooManyDataToWrite if size exceeds the capacity
+// and ErrFrameReserved if a reservation is already pending: there is at most one at a time.
+func (r *RingBuffer) ReserveFrame(size int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.destroyed {
+		return false, ErrDestroyed
+	}
+	if r.closed {
+		return false, ErrIsClosed
+	}
+	if r.frame != nil {
+		return false, ErrFrameReserved
+	}
+	if size < 0 {
+		size = 0
+	}
+	if size > r.capacity() {
+		return false, ErrTooManyDataToWrite
+	}
+	if size > r.free() {
+		return false, nil
+	}
+	r.reserved = size
+	r.frame = make([]byte, 0, size)
+	return true, nil
+}
+
+// WriteReserved appends p to the frame reserved by ReserveFrame. It returns ErrTooManyDataToWrite,
+// appending only what fits, if the frame would grow beyond the reserved size.
+// Only the producer that made the reservation should call it.
+func (r *RingBuffer) WriteReserved(p []byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.frame == nil {
+		return 0, ErrNoFrameReserved
+	}
+	if left := r.reserved - len(r.frame); len(p) > left {
+		p = p[:left]
+		err = ErrTooManyDataToWrite
+	}
+	r.frame = append(r.frame, p...)
+	return len(p), err
+}
+
+// CommitFrame writes the pending frame into the buffer, all at once or not at all, and releases the reservation.
+// The frame may be shorter than the reserved size, the rest of the reserved space is given back.
+// If the frame cannot be written, e.g. writes are paused, the quota would be exceeded or ShrinkTo or SetSoftLimit
+// took the space, nothing is written and the reservation is kept, so the caller can retry or call AbortFrame.
+func (r *RingBuffer) CommitFrame() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.frame == nil {
+		return ErrNoFrameReserved
+	}
+	p := r.frame
+	if err := r.checkFrameWrite(len(p), r.space()); err != nil {
+		return err
+	}
+	r.frame, r.reserved = nil, 0
+	if len(p) == 0 {
+		r.signal()
+		return nil
+	}
+	_, err := r.write(p)
+	return err
+}
+
+// checkFrameWrite returns the error a write of the n bytes of a frame would stop with, given free bytes of space,
+// so that a frame is written all at once or not at all, the caller must hold r.mu.
+func (r *RingBuffer) checkFrameWrite(n, free int) error {
+	if r.destroyed {
+		return ErrDestroyed
+	}
+	if r.closed {
+		return ErrIsClosed
+	}
+	if n == 0 {
+		return nil
+	}
+	if r.writesHeld() {
+		return ErrPaused
+	}
+	if r.quota > 0 && r.written+uint64(n) > r.quota {
+		return ErrQuotaExceeded
+	}
+	if n > r.capacity() {
+		return ErrTooManyDataToWrite
+	}
+	if n > free {
+		return ErrIsFull
+	}
+	return nil
+}
+
+// AbortFrame drops the pending frame, if any, and releases the reservation.
+func (r *RingBuffer) AbortFrame() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.frame == nil {
+		return
+	}
+	r.frame, r.reserved = nil, 0
+	r.signal()
+}
+
+// frameLen decodes the payload length of the next frame, the caller must hold r.mu.
+// It returns ErrIsEmpty if the prefix is not fully buffered and ErrFrameTooLarge if the length is not acceptable.
+func (r *RingBuffer) frameLen(prefixBytes int, bigEndian bool) (int, error) {
+	checkFramePrefix(prefixBytes)
+	if r.destroyed {
+		return 0, ErrDestroyed
+	}
+	var hdr [8]byte
+	if r.length() < prefixBytes {
+		return 0, ErrIsEmpty
+	}
+	r.peek(hdr[:prefixBytes])
+
+	n := getFrameLen(hdr[:prefixBytes], bigEndian)
+	// 先和上限比较再转换成 int，避免一个巨大的长度溢出成负数
+	if r.maxFrame > 0 && n > uint64(r.maxFrame) {
+		return 0, ErrFrameTooLarge
+	}
+	if n > uint64(r.capacity()-prefixBytes) {
+		// 永远也不可能完整地放进 buffer，等下去只会卡死
+		return 0, ErrFrameTooLarge
+	}
+	return int(n), nil
+}
+
+func checkFramePrefix(prefixBytes int) {
+	switch prefixBytes {
+	case 1, 2, 4, 8:
+	default:
+		panic("ringbuffer: frame prefix must be 1, 2, 4 or 8 bytes")
+	}
+}
+
+func getFrameLen(hdr []byte, bigEndian bool) uint64 {
+	var order binary.ByteOrder = binary.LittleEndian
+	if bigEndian {
+		order = binary.BigEndian
+	}
+	switch len(hdr) {
+	case 1:
+		return uint64(hdr[0])
+	case 2:
+		return uint64(order.Uint16(hdr))
+	case 4:
+		return uint64(order.Uint32(hdr))
+	default:
+		return order.Uint64(hdr)
+	}
+}
+
+// putFrameLen encodes n into hdr, it returns false if n does not fit in len(hdr) bytes.
+func putFrameLen(hdr []byte, bigEndian bool, n int) bool {
+	if len(hdr) < 8 && uint64(n) >= 1<<(8*uint(len(hdr))) {
+		return false
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if bigEndian {
+		order = binary.BigEndian
+	}
+	switch len(hdr) {
+	case 1:
+		hdr[0] = byte(n)
+	case 2:
+		order.PutUint16(hdr, uint16(n))
+	case 4:
+		order.PutUint32(hdr, uint32(n))
+	default:
+		order.PutUint64(hdr, uint64(n))
+	}
+	return true
+}
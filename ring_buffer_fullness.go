@@ -0,0 +1,71 @@
+// Copyright 2019 smallnest. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ringbuffer
+
+// FullnessEvents returns a channel receiving true when the buffer becomes full and false when it is no longer full,
+// so that an event driven producer can select on it to know when to resume writing. Fullness is relative to the soft limit.
+// Events are coalesced: the channel holds at most one pending event, replaced by the latest state if the receiver
+// is late, so it never blocks the write path. Every call returns the same channel, which is closed by Close.
+func (r *RingBuffer) FullnessEvents() <-chan bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.fullEvents == nil {
+		r.fullEvents = make(chan bool, 1)
+		if r.closed {
+			close(r.fullEvents)
+			return r.fullEvents
+		}
+		r.lastFull = r.free() == 0
+		if r.lastFull {
+			r.fullEvents <- true
+		}
+	}
+	return r.fullEvents
+}
+
+// notifyFullness sends a fullness event if the buffer became full or not full since the last one, the caller must hold r.mu.
+func (r *RingBuffer) notifyFullness() {
+	if r.fullEvents == nil || r.closed {
+		return
+	}
+	full := r.free() == 0
+	if full == r.lastFull {
+		return
+	}
+	r.lastFull = full
+
+	// 只有持有锁的一方会发送，丢掉尚未被接收的旧事件之后一定有空位
+	select {
+	case <-r.fullEvents:
+	default:
+	}
+	r.fullEvents <- full
+}
+
+// NotifyNonEmpty returns a channel closed on the next transition of the buffer from empty to non-empty,
+// a lighter wakeup than a blocking read for an event loop which wants to know when to start draining.
+// The channel is one-shot: call NotifyNonEmpty again to be notified of the next transition. If the buffer
+// is not empty (or closed) already, the returned channel is closed, so that no data is missed.
+// Calls made before the transition share the same channel, it is also closed by Close.
+func (r *RingBuffer) NotifyNonEmpty() <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.nonEmpty == nil {
+		r.nonEmpty = make(chan struct{})
+	}
+	ch := r.nonEmpty
+	r.notifyNonEmpty()
+	return ch
+}
+
+// notifyNonEmpty closes the channel returned by NotifyNonEmpty once there is data to read, the caller must hold r.mu.
+func (r *RingBuffer) notifyNonEmpty() {
+	if r.nonEmpty != nil && (r.length() > 0 || r.closed) {
+		close(r.nonEmpty)
+		r.nonEmpty = nil
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2019 smallnest. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ringbuffer
+
+import (
+	"hash"
+)
+
+// SetWriteHash makes every write feed the written bytes into h as they are copied into the buffer,
+// so WriteHashSum is the digest of the whole stream written from now on, not only of the buffered bytes.
+// A nil h disables it. h must not be used elsewhere while it is set.
+func (r *RingBuffer) SetWriteHash(h hash.Hash) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.writeHash = h
+}
+
+// WriteHashSum returns the current digest of the hash set by SetWriteHash, or nil if there is none.
+func (r *RingBuffer) WriteHashSum() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.writeHash == nil {
+		return nil
+	}
+	return r.writeHash.Sum(nil)
+}
+
+// SetReadHash makes every read feed the consumed bytes into h, so ReadHashSum is the digest of the whole stream
+// read from now on. Comparing it with the WriteHashSum of the producer detects corruption end to end.
+// Bytes dropped by overwrite writes or resets are not fed.
+// A nil h disables it. h must not be used elsewhere while it is set.
+func (r *RingBuffer) SetReadHash(h hash.Hash) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.readHash = h
+}
+
+// ReadHashSum returns the current digest of the hash set by SetReadHash, or nil if there is none.
+func (r *RingBuffer) ReadHashSum() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.readHash == nil {
+		return nil
+	}
+	return r.readHash.Sum(nil)
+}
+
+// hashRange feeds the n bytes of buf starting at position start into h, wrapping at the end of buf,
+// the caller must hold r.mu.
+func (r *RingBuffer) hashRange(h hash.Hash, start, n int) {
+	if c1 := r.size - start; n > c1 {
+		h.Write(r.buf[start:])
+		h.Write(r.buf[:n-c1])
+		return
+	}
+	h.Write(r.buf[start : start+n])
+}
@@ -0,0 +1,54 @@
+// Copyright 2019 smallnest. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ringbuffer
+
+import (
+	"syscall"
+)
+
+// ReadFromFd does a single read(2) from fd straight into the free space of the underlying buffer,
+// without going through an io.Reader. It only fills the contiguous free region after the write pointer,
+// so a caller wanting to fill the buffer loops on it. It returns ErrIsFull if there is no free space,
+// (0, nil) means read(2) reached end of file.
+// The lock is held during the system call, so fd should be non-blocking or known to be readable.
+func (r *RingBuffer) ReadFromFd(fd int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.destroyed {
+		return 0, ErrDestroyed
+	}
+	if r.closed {
+		return 0, ErrIsClosed
+	}
+	if r.writesHeld() {
+		return 0, ErrPaused
+	}
+	if r.quota > 0 && r.written >= r.quota {
+		return 0, ErrQuotaExceeded
+	}
+	free := r.free()
+	if free == 0 {
+		return 0, ErrIsFull
+	}
+
+	// 只读入 w 之后连续的那一段空闲区域: w -> size 或 w -> r
+	end := r.size
+	if r.w < r.r {
+		end = r.r
+	}
+	if end-r.w > free {
+		end = r.w + free
+	}
+	if r.quota > 0 && uint64(end-r.w) > r.quota-r.written {
+		end = r.w + int(r.quota-r.written)
+	}
+	n, err := syscall.Read(fd, r.buf[r.w:end])
+	if n < 0 {
+		n = 0
+	}
+	r.advance(n)
+	return n, err
+}
@@ -0,0 +1,35 @@
+package ringbuffer
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRingBuffer_ReadFromFd(t *testing.T) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe failed: %v", err)
+	}
+	defer pr.Close()
+	defer pw.Close()
+
+	rb := New(8)
+	rb.Write([]byte("xxxxxx"))
+	rb.Read(make([]byte, 4))
+
+	pw.Write([]byte("abcdef"))
+	n, err := rb.ReadFromFd(int(pr.Fd()))
+	if err != nil || n != 2 {
+		t.Fatalf("expect read 2 bytes before the wrap but got %d, %v", n, err)
+	}
+	n, err = rb.ReadFromFd(int(pr.Fd()))
+	if err != nil || n != 4 {
+		t.Fatalf("expect read 4 bytes after the wrap but got %d, %v", n, err)
+	}
+	if string(rb.Bytes()) != "xxabcdef" {
+		t.Fatalf("expect xxabcdef but got %s", rb.Bytes())
+	}
+	if _, err = rb.ReadFromFd(int(pr.Fd())); err != ErrIsFull {
+		t.Fatalf("expect ErrIsFull but got %v", err)
+	}
+}
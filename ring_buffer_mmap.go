@@ -0,0 +1,51 @@
+// Copyright 2019 smallnest. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ringbuffer
+
+import (
+	"encoding/binary"
+)
+
+// The file behind a buffer created by NewMapped starts with a header of mappedHeaderSize bytes,
+// followed by size bytes of data. All integers are little endian:
+//
+//	[0:8]   magic "RINGBUF1"
+//	[8:16]  size of the data region
+//	[16:24] read position
+//	[24:32] write position
+//	[32]    1 if the buffer is full, 0 otherwise
+//	[33:64] reserved
+const (
+	mappedHeaderSize = 64
+	mappedMagic      = "RINGBUF1"
+)
+
+// putMappedHeader stores the positions of r into its mapping header, the caller must hold r.mu.
+func (r *RingBuffer) putMappedHeader() {
+	h := r.mapping[:mappedHeaderSize]
+	copy(h, mappedMagic)
+	binary.LittleEndian.PutUint64(h[8:], uint64(r.size))
+	binary.LittleEndian.PutUint64(h[16:], uint64(r.r))
+	binary.LittleEndian.PutUint64(h[24:], uint64(r.w))
+	h[32] = 0
+	if r.isFull {
+		h[32] = 1
+	}
+}
+
+// getMappedHeader restores the positions of r from its mapping header, the caller must hold r.mu.
+func (r *RingBuffer) getMappedHeader() error {
+	h := r.mapping[:mappedHeaderSize]
+	if string(h[:8]) != mappedMagic || binary.LittleEndian.Uint64(h[8:]) != uint64(r.size) {
+		return ErrBadMappedFile
+	}
+	rp := binary.LittleEndian.Uint64(h[16:])
+	wp := binary.LittleEndian.Uint64(h[24:])
+	if rp >= uint64(r.size) || wp >= uint64(r.size) || h[32] > 1 || (h[32] == 1 && rp != wp) {
+		return ErrBadMappedFile
+	}
+	r.r, r.w, r.isFull = int(rp), int(wp), h[32] == 1
+	return nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 smallnest. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ringbuffer
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// NewMapped returns a RingBuffer of the given size whose data lives in the file at path, mapped into memory,
+// so buffered data survives process restarts. A new or empty file is created and initialized,
+// an existing file must have been created by NewMapped with the same size; its read and write positions are recovered.
+//
+// Durability: bytes written to the buffer are in the shared mapping immediately and survive a process crash,
+// but the read and write positions are only persisted by Sync, and only Sync flushes the mapping to disk.
+// After a crash the buffer reopens at the positions of the last Sync. Call Sync before Destroy,
+// which unmaps the file, to keep the latest positions.
+func NewMapped(path string, size int) (*RingBuffer, error) {
+	if size <= 0 {
+		return nil, ErrBadMappedFile
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	fresh := fi.Size() == 0
+	if fresh {
+		if err = f.Truncate(int64(mappedHeaderSize + size)); err != nil {
+			return nil, err
+		}
+	} else if fi.Size() != int64(mappedHeaderSize+size) {
+		return nil, ErrBadMappedFile
+	}
+
+	mapping, err := syscall.Mmap(int(f.Fd()), 0, mappedHeaderSize+size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+
+	rb := newWithBuf(mapping[mappedHeaderSize:])
+	rb.mapping = mapping
+	rb.freeHook = func([]byte) error {
+		return syscall.Munmap(mapping)
+	}
+	if fresh {
+		rb.putMappedHeader()
+	} else if err = rb.getMappedHeader(); err != nil {
+		syscall.Munmap(mapping)
+		return nil, err
+	}
+	return rb, nil
+}
+
+// Sync persists the read and write positions into the header of a buffer created by NewMapped
+// and flushes the whole mapping to disk. It is a no-op for other buffers.
+func (r *RingBuffer) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.mapping == nil {
+		return nil
+	}
+	r.putMappedHeader()
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(&r.mapping[0])), uintptr(len(r.mapping)), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
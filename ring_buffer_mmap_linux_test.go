@@ -0,0 +1,38 @@
+package ringbuffer
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRingBuffer_NewMapped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rb.map")
+
+	rb, err := NewMapped(path, 8)
+	if err != nil {
+		t.Fatalf("NewMapped failed: %v", err)
+	}
+	rb.Write([]byte("012345"))
+	rb.Read(make([]byte, 4))
+	rb.Write([]byte("abcd"))
+	if err = rb.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if err = rb.Destroy(); err != nil {
+		t.Fatalf("Destroy failed: %v", err)
+	}
+
+	// reopen, positions and data are recovered
+	rb, err = NewMapped(path, 8)
+	if err != nil {
+		t.Fatalf("NewMapped failed: %v", err)
+	}
+	defer rb.Destroy()
+	if string(rb.Bytes()) != "45abcd" {
+		t.Fatalf("expect 45abcd but got %s. r.w=%d, r.r=%d", rb.Bytes(), rb.w, rb.r)
+	}
+
+	if _, err = NewMapped(path, 16); err != ErrBadMappedFile {
+		t.Fatalf("expect ErrBadMappedFile but got %v", err)
+	}
+}
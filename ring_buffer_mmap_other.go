@@ -0,0 +1,18 @@
+// Copyright 2019 smallnest. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package ringbuffer
+
+// NewMapped is only supported on linux and returns ErrNotSupported elsewhere.
+func NewMapped(path string, size int) (*RingBuffer, error) {
+	return nil, ErrNotSupported
+}
+
+// Sync is a no-op on platforms without NewMapped support.
+func (r *RingBuffer) Sync() error {
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2019 smallnest. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ringbuffer
+
+// OpKind is the kind of operation recorded in the operation log, see EnableOpLog.
+type OpKind int
+
+const (
+	// OpRead records bytes consumed by a read.
+	OpRead OpKind = iota
+	// OpWrite records bytes written.
+	OpWrite
+	// OpEvict records bytes dropped by an overwrite write.
+	OpEvict
+	// OpReset records a Reset, ResetWrite or ResetRead, N is the number of bytes buffered before it.
+	OpReset
+)
+
+// String returns the name of the operation kind.
+func (k OpKind) String() string {
+	switch k {
+	case OpRead:
+		return "read"
+	case OpWrite:
+		return "write"
+	case OpEvict:
+		return "evict"
+	case OpReset:
+		return "reset"
+	}
+	return "unknown"
+}
+
+// Op is an entry of the operation log: what happened, to how many bytes, and the resulting positions.
+type Op struct {
+	Kind OpKind
+	N    int
+	R    int // read position after the operation
+	W    int // write position after the operation
+	Full bool
+}
+
+// EnableOpLog keeps a record of the last n operations, returned by OpLog, to diagnose how a buffer
+// ended up in an unexpected state, e.g. when it is misused concurrently. A n <= 0 disables it.
+// The log costs nothing when disabled.
+func (r *RingBuffer) EnableOpLog(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.opLog, r.opNext, r.opCount = nil, 0, 0
+	if n > 0 {
+		r.opLog = make([]Op, n)
+	}
+}
+
+// OpLog returns a copy of the recorded operations, oldest first.
+func (r *RingBuffer) OpLog() []Op {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ops := make([]Op, 0, r.opCount)
+	start := r.opNext - r.opCount
+	if start < 0 {
+		start += len(r.opLog)
+	}
+	for i := 0; i < r.opCount; i++ {
+		ops = append(ops, r.opLog[(start+i)%len(r.opLog)])
+	}
+	return ops
+}
+
+// logOp records an operation once it is done, the caller must hold r.mu and have checked r.opLog != nil.
+func (r *RingBuffer) logOp(kind OpKind, n int) {
+	r.opLog[r.opNext] = Op{Kind: kind, N: n, R: r.r, W: r.w, Full: r.isFull}
+	r.opNext++
+	if r.opNext == len(r.opLog) {
+		r.opNext = 0
+	}
+	if r.opCount < len(r.opLog) {
+		r.opCount++
+	}
+}
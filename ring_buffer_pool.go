@@ -0,0 +1,100 @@
+// Copyright 2019 smallnest. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ringbuffer
+
+import (
+	"sync"
+)
+
+// Pool recycles RingBuffers to reduce allocations when many short-lived buffers are needed,
+// e.g. one per connection. Buffers are kept in one sync.Pool per capacity, so Get always returns
+// a buffer of exactly the requested size. The zero value is ready to use.
+type Pool struct {
+	// Zero clears the underlying buffer of every buffer put back, so its data never leaks to the next user.
+	Zero bool
+
+	mu    sync.Mutex
+	pools map[int]*sync.Pool
+}
+
+// Get returns an empty RingBuffer of the given size, reused from the pool if possible.
+func (p *Pool) Get(size int) *RingBuffer {
+	if rb, _ := p.pool(size).Get().(*RingBuffer); rb != nil {
+		return rb
+	}
+	return New(size)
+}
+
+// Put resets rb and puts it back into the pool. rb must not be used after Put.
+// All settings of rb (overwrite mode, callbacks, ...) are cleared. Buffers created by NewMapped
+// or with a free hook are not pooled, Put leaves them alone.
+func (p *Pool) Put(rb *RingBuffer) {
+	if rb == nil {
+		return
+	}
+	rb.mu.Lock()
+	if rb.freeHook != nil || rb.mapping != nil || rb.buf == nil {
+		rb.mu.Unlock()
+		return
+	}
+	// 先关闭，停掉 idle 和 stall timer、fullness sampler 的 goroutine，并关闭 FullnessEvents 的 channel
+	rb.close()
+	if p.Zero {
+		for i := range rb.buf {
+			rb.buf[i] = 0
+		}
+	}
+	rb.recycle()
+	rb.mu.Unlock()
+
+	p.pool(rb.size).Put(rb)
+}
+
+// recycle clears every setting and all the state of r but its underlying buffer and moves r to the next epoch,
+// so that the callbacks of timers armed before do nothing, the caller must hold r.mu.
+// 不能直接给 *r 赋零值: 已经触发的 timer 回调或 sampler 可能正在等 r.mu。新增字段时要在这里一起重置。
+func (r *RingBuffer) recycle() {
+	r.r, r.w, r.isFull, r.closed = 0, 0, false, false
+	r.paused, r.fences, r.block = false, 0, false
+	r.cond = nil
+	r.written, r.quota, r.readOff = 0, 0, 0
+	r.overwrite, r.evicted, r.softLimit = false, 0, 0
+	r.limiter = nil
+	r.minRead = 0
+	r.handoff, r.handing = nil, false
+	r.fair, r.readers, r.writers = false, ticketQueue{}, ticketQueue{}
+	r.trackAge, r.stamps = false, nil
+	r.destroyed = false
+	r.epoch++
+	r.idleDelay, r.idleTimer, r.idleFn = 0, nil, nil
+	r.stallDelay, r.stallTimer, r.stallFn, r.stallArmed = 0, nil, nil, false
+	r.maxFrame = 0
+	r.jsonData, r.readPool = false, nil
+	r.fullEvents, r.lastFull = nil, false
+	r.nonEmpty = nil
+	r.samplerStop, r.fillSamples = nil, [10]int{}
+	r.userData, r.name, r.distNext = nil, "", 0
+	r.stage, r.staged = nil, 0
+	r.opLog, r.opNext, r.opCount = nil, 0, 0
+	r.histBounds, r.histCounts = nil, nil
+	r.writeHash, r.readHash = nil, nil
+	r.reserved, r.frame = 0, nil
+}
+
+// pool returns the sync.Pool holding buffers of the given size.
+func (p *Pool) pool(size int) *sync.Pool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pools == nil {
+		p.pools = make(map[int]*sync.Pool)
+	}
+	sp := p.pools[size]
+	if sp == nil {
+		sp = &sync.Pool{}
+		p.pools[size] = sp
+	}
+	return sp
+}
@@ -0,0 +1,99 @@
+// Copyright 2019 smallnest. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ringbuffer
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// defaultReadPool is used by ReadPooled when no pool is set by SetReadPool.
+var defaultReadPool = &sync.Pool{}
+
+// PooledBuf holds bytes consumed by ReadPooled in a slice taken from a sync.Pool.
+// It is reference counted: it starts with one reference, Retain adds one and Release drops one.
+// When the last reference is released the slice goes back to the pool, so Bytes must not be used after that.
+type PooledBuf struct {
+	buf  *[]byte
+	n    int
+	refs int32
+	pool *sync.Pool
+}
+
+// Bytes returns the bytes read, valid until the last Release.
+func (b *PooledBuf) Bytes() []byte {
+	return (*b.buf)[:b.n]
+}
+
+// Len returns the number of bytes read.
+func (b *PooledBuf) Len() int {
+	return b.n
+}
+
+// Retain adds a reference, for handing the buffer to another owner which will Release it.
+func (b *PooledBuf) Retain() {
+	if atomic.AddInt32(&b.refs, 1) <= 1 {
+		panic("ringbuffer: Retain of a released PooledBuf")
+	}
+}
+
+// Release drops a reference and returns the slice to its pool when it was the last one.
+func (b *PooledBuf) Release() {
+	refs := atomic.AddInt32(&b.refs, -1)
+	if refs < 0 {
+		panic("ringbuffer: PooledBuf released too many times")
+	}
+	if refs == 0 {
+		b.pool.Put(b.buf)
+		b.buf = nil
+	}
+}
+
+// SetReadPool sets the pool ReadPooled takes its slices from. The pool must hold *[]byte values,
+// its New function may be nil. A nil pool restores the package default pool.
+func (r *RingBuffer) SetReadPool(pool *sync.Pool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.readPool = pool
+}
+
+// ReadPooled reads up to max bytes into a slice taken from the read pool and returns it as a PooledBuf,
+// which must be released once consumed. It returns ErrIsEmpty if the buffer is empty.
+// A pooled slice too short for the bytes read is put back and replaced by a new one of just the right length.
+// A max <= 0 means no cap.
+func (r *RingBuffer) ReadPooled(max int) (*PooledBuf, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.destroyed {
+		return nil, ErrDestroyed
+	}
+	if r.w == r.r && !r.isFull {
+		return nil, ErrIsEmpty
+	}
+	n := r.length()
+	if max > 0 && n > max {
+		n = max
+	}
+
+	pool := r.readPool
+	if pool == nil {
+		pool = defaultReadPool
+	}
+	buf, _ := pool.Get().(*[]byte)
+	if buf == nil || cap(*buf) < n {
+		if buf != nil {
+			pool.Put(buf)
+		}
+		p := make([]byte, n)
+		buf = &p
+	}
+	*buf = (*buf)[:cap(*buf)]
+
+	r.peek((*buf)[:n])
+	r.consume(n)
+	return &PooledBuf{buf: buf, n: n, refs: 1, pool: pool}, nil
+}
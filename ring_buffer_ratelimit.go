@@ -0,0 +1,67 @@
+// Copyright 2019 smallnest. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ringbuffer
+
+import (
+	"time"
+)
+
+// SetWriteRateLimit throttles WriteBlocking and WriteContext to bytesPerSec with a token bucket,
+// sleeping as needed. Bursts of up to one second worth of bytes are allowed. Write and the other
+// non-blocking writes are not paced. A bytesPerSec <= 0 removes the limit.
+func (r *RingBuffer) SetWriteRateLimit(bytesPerSec int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if bytesPerSec <= 0 {
+		r.limiter = nil
+		return
+	}
+	r.limiter = newTokenBucket(bytesPerSec, time.Now())
+}
+
+// tokenBucket is a token bucket of one token per byte, it is protected by the lock of its ringbuffer.
+type tokenBucket struct {
+	rate   float64 // tokens per second, also the capacity of the bucket
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: float64(rate), tokens: float64(rate), last: now}
+}
+
+// take takes up to n tokens and returns how many it got. When the bucket is empty it returns 0 and
+// how long to wait for a reasonable amount of tokens, at least one and at most 10ms worth of them.
+func (b *tokenBucket) take(n int, now time.Time) (int, time.Duration) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		if float64(n) > b.tokens {
+			n = int(b.tokens)
+		}
+		b.tokens -= float64(n)
+		return n, 0
+	}
+
+	// 桶空了: 等到攒够 min(n, 10ms 的量) 个 token 再写，避免每个 byte 都睡一次
+	want := b.rate / 100
+	if want > float64(n) {
+		want = float64(n)
+	}
+	if want < 1 {
+		want = 1
+	}
+	return 0, time.Duration((want - b.tokens) / b.rate * float64(time.Second))
+}
+
+// giveBack returns n tokens taken but not used.
+func (b *tokenBucket) giveBack(n int) {
+	b.tokens += float64(n)
+}
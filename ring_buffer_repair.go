@@ -0,0 +1,70 @@
+// Copyright 2019 smallnest. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ringbuffer
+
+import (
+	"fmt"
+	"time"
+)
+
+// Repair brings the internal state back to a consistent one after an out-of-band mutation, e.g. positions recovered
+// from a damaged mapped file or a buffer built by NewInState: the size is matched to the underlying buffer,
+// the read and write positions are brought into [0, size), a buffer whose positions differ is not full,
+// and the age tracking stamps are rebuilt if they do not add up to the buffered length.
+// It returns a description of each change, nil if the state was already consistent.
+// The buffered bytes themselves cannot be checked, Repair only makes the pointers safe to use.
+func (r *RingBuffer) Repair() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var fixes []string
+	if r.size != len(r.buf) {
+		fixes = append(fixes, fmt.Sprintf("size %d set to the buffer length %d", r.size, len(r.buf)))
+		r.size = len(r.buf)
+	}
+	if r.pow2 && r.size&(r.size-1) != 0 {
+		fixes = append(fixes, fmt.Sprintf("size %d is not a power of two, bit mask wrapping disabled", r.size))
+		r.pow2 = false
+	}
+	r.r = r.repairPos("r", r.r, &fixes)
+	r.w = r.repairPos("w", r.w, &fixes)
+	if r.isFull && (r.r != r.w || r.size == 0) {
+		fixes = append(fixes, fmt.Sprintf("full flag cleared, r %d and w %d differ", r.r, r.w))
+		r.isFull = false
+	}
+
+	if r.trackAge {
+		total := 0
+		for _, s := range r.stamps {
+			total += s.n
+		}
+		if n := r.length(); total != n {
+			fixes = append(fixes, fmt.Sprintf("age stamps covered %d bytes instead of %d, reset", total, n))
+			r.stamps = nil
+			if n > 0 {
+				r.stamps = append(r.stamps, writeStamp{n: n, t: time.Now()})
+			}
+		}
+	}
+	if fixes != nil {
+		r.signal()
+	}
+	return fixes
+}
+
+// repairPos brings position i into [0, size), the caller must hold r.mu.
+func (r *RingBuffer) repairPos(name string, i int, fixes *[]string) int {
+	j := i
+	switch {
+	case r.size == 0 || i < 0:
+		j = 0
+	case i >= r.size:
+		j = i % r.size
+	}
+	if j != i {
+		*fixes = append(*fixes, fmt.Sprintf("%s %d moved to %d", name, i, j))
+	}
+	return j
+}
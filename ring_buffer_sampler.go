@@ -0,0 +1,86 @@
+// Copyright 2019 smallnest. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ringbuffer
+
+import (
+	"time"
+)
+
+// EnableFullnessSampler starts a goroutine sampling Length()/capacity every interval, where capacity is the soft limit
+// if set, so that FullnessDistribution can tell whether the buffer is chronically near full or mostly idle.
+// Calling it again restarts the sampling from scratch, a zero interval stops it. The goroutine is stopped by Close.
+func (r *RingBuffer) EnableFullnessSampler(interval time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stopSampler()
+	r.fillSamples = [10]int{}
+	if interval <= 0 || r.closed {
+		return
+	}
+
+	stop := make(chan struct{})
+	r.samplerStop = stop
+	go r.sample(interval, stop)
+}
+
+// FullnessDistribution returns the fraction of the samples taken by the fullness sampler in each decile of fullness:
+// the first value for a buffer filled below 10%, the last one from 90% to full. It returns nil before the first sample.
+func (r *RingBuffer) FullnessDistribution() []float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	total := 0
+	for _, c := range r.fillSamples {
+		total += c
+	}
+	if total == 0 {
+		return nil
+	}
+	dist := make([]float64, len(r.fillSamples))
+	for i, c := range r.fillSamples {
+		dist[i] = float64(c) / float64(total)
+	}
+	return dist
+}
+
+func (r *RingBuffer) sample(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		r.mu.Lock()
+		// 等锁期间可能已经被停掉了
+		if r.samplerStop == stop {
+			r.recordFullness()
+		}
+		r.mu.Unlock()
+	}
+}
+
+// recordFullness adds the current fullness to the samples, the caller must hold r.mu.
+func (r *RingBuffer) recordFullness() {
+	i := len(r.fillSamples) - 1
+	if c := r.capacity(); c > 0 {
+		if d := r.length() * len(r.fillSamples) / c; d < i {
+			i = d
+		}
+	}
+	r.fillSamples[i]++
+}
+
+// stopSampler stops the fullness sampler goroutine if it runs, the caller must hold r.mu.
+func (r *RingBuffer) stopSampler() {
+	if r.samplerStop != nil {
+		close(r.samplerStop)
+		r.samplerStop = nil
+	}
+}
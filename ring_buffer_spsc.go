@@ -0,0 +1,109 @@
+// Copyright 2019 smallnest. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ringbuffer
+
+import (
+	"sync/atomic"
+)
+
+// SPSCRingBuffer is a lock-free ringbuffer for exactly one producer goroutine and one consumer goroutine:
+// only the producer may call Write and WriteByte, and only the consumer Read and ReadByte.
+// Each side publishes its own position with a single atomic store and reads the other side's with a single atomic load,
+// so the single-byte path costs one load and one store instead of a mutex.
+type SPSCRingBuffer struct {
+	// head 和 tail 都是单调递增的计数，位置是 & mask 之后的值，所以 tail-head 就是长度，无需 isFull。
+	// 放在最前面保证在 32 位平台上 64 位对齐，中间的 padding 避免两个 goroutine 争抢同一个 cache line。
+	head uint64 // next byte to read, only stored by the consumer
+	_    [56]byte
+	tail uint64 // next byte to write, only stored by the producer
+	_    [56]byte
+
+	buf  []byte
+	mask uint64
+}
+
+// NewSPSC returns a new SPSCRingBuffer whose size is minSize rounded up to the next power of two.
+func NewSPSC(minSize int) *SPSCRingBuffer {
+	size := 1
+	for size < minSize {
+		size <<= 1
+	}
+	return &SPSCRingBuffer{
+		buf:  make([]byte, size),
+		mask: uint64(size - 1),
+	}
+}
+
+// Capacity returns the size of the underlying buffer.
+func (r *SPSCRingBuffer) Capacity() int {
+	return len(r.buf)
+}
+
+// Length returns the number of readable bytes, it is only exact when called by the producer or the consumer.
+func (r *SPSCRingBuffer) Length() int {
+	return int(atomic.LoadUint64(&r.tail) - atomic.LoadUint64(&r.head))
+}
+
+// WriteByte writes one byte, or returns ErrIsFull. It must only be called by the producer.
+func (r *SPSCRingBuffer) WriteByte(c byte) error {
+	tail := r.tail // 只有 producer 会修改 tail，不需要原子读
+	if tail-atomic.LoadUint64(&r.head) == uint64(len(r.buf)) {
+		return ErrIsFull
+	}
+	r.buf[tail&r.mask] = c
+	atomic.StoreUint64(&r.tail, tail+1)
+	return nil
+}
+
+// ReadByte reads one byte, or returns ErrIsEmpty. It must only be called by the consumer.
+func (r *SPSCRingBuffer) ReadByte() (byte, error) {
+	head := r.head // 只有 consumer 会修改 head
+	if head == atomic.LoadUint64(&r.tail) {
+		return 0, ErrIsEmpty
+	}
+	c := r.buf[head&r.mask]
+	atomic.StoreUint64(&r.head, head+1)
+	return c, nil
+}
+
+// Write writes as many bytes of p as fit, with the same semantics as RingBuffer.Write.
+// It must only be called by the producer.
+func (r *SPSCRingBuffer) Write(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	tail := r.tail
+	free := len(r.buf) - int(tail-atomic.LoadUint64(&r.head))
+	if free == 0 {
+		return 0, ErrIsFull
+	}
+	if len(p) > free {
+		p = p[:free]
+		err = ErrTooManyDataToWrite
+	}
+	n = copy(r.buf[tail&r.mask:], p)
+	copy(r.buf, p[n:])
+	atomic.StoreUint64(&r.tail, tail+uint64(len(p)))
+	return len(p), err
+}
+
+// Read reads up to len(p) bytes into p, or returns ErrIsEmpty. It must only be called by the consumer.
+func (r *SPSCRingBuffer) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	head := r.head
+	avail := int(atomic.LoadUint64(&r.tail) - head)
+	if avail == 0 {
+		return 0, ErrIsEmpty
+	}
+	if len(p) > avail {
+		p = p[:avail]
+	}
+	n = copy(p, r.buf[head&r.mask:])
+	copy(p[n:], r.buf)
+	atomic.StoreUint64(&r.head, head+uint64(len(p)))
+	return len(p), nil
+}
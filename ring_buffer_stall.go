@@ -0,0 +1,84 @@
+// Copyright 2019 smallnest. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ringbuffer
+
+import (
+	"time"
+)
+
+// SetStallCallback arranges for cb to be called with the number of buffered bytes when data stays unread
+// for threshold, which usually means the consumer has stalled. The timer starts when data is written
+// to an empty buffer and restarts on each read, it is stopped once the buffer is drained and by Close.
+// cb is called at most once per stall, from its own goroutine without holding the lock.
+// A zero threshold or a nil cb disables it.
+func (r *RingBuffer) SetStallCallback(threshold time.Duration, cb func(bufferedBytes int)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stopStallTimer()
+	if threshold <= 0 || cb == nil || r.closed {
+		r.stallDelay, r.stallFn = 0, nil
+		return
+	}
+
+	r.stallDelay, r.stallFn = threshold, cb
+	epoch := r.epoch
+	r.stallTimer = time.AfterFunc(threshold, func() { r.stallFire(epoch) })
+	r.stallArmed = true
+	if r.length() == 0 {
+		r.stallTimer.Stop()
+		r.stallArmed = false
+	}
+}
+
+// armStallTimer starts the stall timer after a write unless it is already running, the caller must hold r.mu.
+// 写入不会推迟已经在计时的 timer，否则持续写入会把一个卡住的 consumer 掩盖掉
+func (r *RingBuffer) armStallTimer() {
+	if r.stallTimer != nil && !r.stallArmed {
+		r.stallTimer.Reset(r.stallDelay)
+		r.stallArmed = true
+	}
+}
+
+// resetStallTimer restarts the stall timer after a read, or stops it if the buffer is drained, the caller must hold r.mu.
+func (r *RingBuffer) resetStallTimer() {
+	if r.stallTimer == nil {
+		return
+	}
+	if r.length() == 0 {
+		r.stallTimer.Stop()
+		r.stallArmed = false
+		return
+	}
+	r.stallTimer.Reset(r.stallDelay)
+	r.stallArmed = true
+}
+
+// stopStallTimer stops and forgets the stall timer, the caller must hold r.mu.
+func (r *RingBuffer) stopStallTimer() {
+	if r.stallTimer != nil {
+		r.stallTimer.Stop()
+		r.stallTimer = nil
+	}
+	r.stallArmed = false
+}
+
+func (r *RingBuffer) stallFire(epoch uint64) {
+	r.mu.Lock()
+	if r.epoch != epoch {
+		// buffer 已经被 Pool 回收，这是上一个使用者的 timer
+		r.mu.Unlock()
+		return
+	}
+	fn := r.stallFn
+	n := r.length()
+	stalled := r.stallTimer != nil && r.stallArmed && n > 0
+	r.stallArmed = false
+	r.mu.Unlock()
+
+	if stalled && fn != nil {
+		fn(n)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2019 smallnest. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ringbuffer
+
+import (
+	"time"
+)
+
+// State is an in-memory snapshot of a RingBuffer taken by SaveState, with a copy of the whole underlying buffer.
+// Its content is opaque, it is only meant to be passed to RestoreState.
+type State struct {
+	buf     []byte
+	r, w    int
+	isFull  bool
+	written uint64
+	readOff uint64
+}
+
+// SaveState takes a snapshot of the positions and the underlying buffer, e.g. to checkpoint a buffer
+// in a replay or fuzz test and roll it back later with RestoreState.
+func (r *RingBuffer) SaveState() State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return State{
+		buf:     append([]byte(nil), r.buf...),
+		r:       r.r,
+		w:       r.w,
+		isFull:  r.isFull,
+		written: r.written,
+		readOff: r.readOff,
+	}
+}
+
+// RestoreState puts the ringbuffer back in the state s saved by SaveState, discarding the current content.
+// If s was saved from a buffer of another size, the underlying buffer is replaced by one of that size.
+// Age tracking stamps are reset, as if the restored bytes had just been written.
+// It returns ErrDestroyed after Destroy, and ErrNotSupported when the sizes differ but the underlying buffer
+// cannot be replaced (NewWithAllocator, NewMapped), leaving the ringbuffer unchanged.
+func (r *RingBuffer) RestoreState(s State) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.destroyed {
+		return ErrDestroyed
+	}
+	if len(s.buf) != r.size {
+		if err := r.checkResizable(); err != nil {
+			return err
+		}
+		r.buf = make([]byte, len(s.buf))
+		r.size = len(s.buf)
+		r.pow2 = r.pow2 && r.size&(r.size-1) == 0
+	}
+	copy(r.buf, s.buf)
+	r.r = s.r
+	r.w = s.w
+	r.isFull = s.isFull
+	r.written = s.written
+	r.readOff = s.readOff
+	r.stamps = nil
+	if n := r.length(); r.trackAge && n > 0 {
+		r.stamps = append(r.stamps, writeStamp{n: n, t: time.Now()})
+	}
+	r.signal()
+	return nil
+}
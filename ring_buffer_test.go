@@ -2,9 +2,20 @@ package ringbuffer
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
 	"io"
+	"math"
+	"net"
+	"os"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestRingBuffer_interface(t *testing.T) {
@@ -413,3 +424,3043 @@ func TestRingBuffer_ByteInterface(t *testing.T) {
 		t.Fatalf("expect IsFull is false but got true")
 	}
 }
+
+func TestRingBuffer_Usage(t *testing.T) {
+	rb := New(64)
+
+	used, free, capacity := rb.Usage()
+	if used != 0 || free != 64 || capacity != 64 {
+		t.Fatalf("expect usage 0/64/64 but got %d/%d/%d", used, free, capacity)
+	}
+
+	rb.Write([]byte(strings.Repeat("abcd", 4)))
+	used, free, capacity = rb.Usage()
+	if used != 16 || free != 48 || capacity != 64 {
+		t.Fatalf("expect usage 16/48/64 but got %d/%d/%d. r.w=%d, r.r=%d", used, free, capacity, rb.w, rb.r)
+	}
+
+	rb.Write([]byte(strings.Repeat("abcd", 12)))
+	used, free, _ = rb.Usage()
+	if used != 64 || free != 0 {
+		t.Fatalf("expect usage 64/0 but got %d/%d. r.w=%d, r.r=%d", used, free, rb.w, rb.r)
+	}
+
+	rb = New(64)
+	rb.SetSoftLimit(32)
+	rb.Write([]byte("abcd"))
+	if used, free, capacity = rb.Usage(); used != 4 || free != 28 || capacity != 32 {
+		t.Fatalf("expect usage 4/28/32 under a soft limit but got %d/%d/%d", used, free, capacity)
+	}
+}
+
+func TestRingBuffer_NewWithAllocator(t *testing.T) {
+	arena := make([]byte, 128)
+	var freed []byte
+	rb := NewWithAllocator(64, func(size int) []byte {
+		return arena[:size]
+	})
+	rb.SetFreeHook(func(buf []byte) error {
+		freed = buf
+		return nil
+	})
+
+	if rb.Capacity() != 64 {
+		t.Fatalf("expect capacity 64 but got %d", rb.Capacity())
+	}
+	rb.Write([]byte("abcd"))
+	if !bytes.Equal(arena[:4], []byte("abcd")) {
+		t.Fatalf("expect data in arena but got %s", arena[:4])
+	}
+
+	if err := rb.Destroy(); err != nil {
+		t.Fatalf("Destroy failed: %v", err)
+	}
+	if len(freed) != 64 || &freed[0] != &arena[0] {
+		t.Fatalf("expect free hook called with the allocated buffer")
+	}
+}
+
+func TestRingBuffer_SetIdleFlush(t *testing.T) {
+	rb := New(64)
+	fired := make(chan struct{}, 1)
+	rb.SetIdleFlush(20*time.Millisecond, func() {
+		fired <- struct{}{}
+	})
+
+	// no data, should not fire
+	select {
+	case <-fired:
+		t.Fatalf("expect no idle flush on an empty buffer")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	rb.Write([]byte("abcd"))
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatalf("expect idle flush after write")
+	}
+
+	rb.Close()
+	rb.Reset()
+	if _, err := rb.Write([]byte("abcd")); err != ErrIsClosed {
+		t.Fatalf("expect ErrIsClosed but got %v", err)
+	}
+	select {
+	case <-fired:
+		t.Fatalf("expect no idle flush after close")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRingBuffer_ReadByteContext(t *testing.T) {
+	rb := New(4)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		rb.WriteByte('a')
+	}()
+	b, err := rb.ReadByteContext(context.Background())
+	if err != nil {
+		t.Fatalf("ReadByteContext failed: %v", err)
+	}
+	if b != 'a' {
+		t.Fatalf("expect a but got %c", b)
+	}
+
+	// cancellation
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = rb.ReadByteContext(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expect context.DeadlineExceeded but got %v", err)
+	}
+
+	// buffered data is still readable after close
+	rb.WriteByte('b')
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		rb.Close()
+	}()
+	b, err = rb.ReadByteContext(context.Background())
+	if err != nil || b != 'b' {
+		t.Fatalf("expect b but got %c, %v", b, err)
+	}
+	_, err = rb.ReadByteContext(context.Background())
+	if err != ErrIsClosed {
+		t.Fatalf("expect ErrIsClosed but got %v", err)
+	}
+}
+
+func TestRingBuffer_Prefault(t *testing.T) {
+	rb := New(3*os.Getpagesize() + 1)
+	rb.Prefault()
+	if !rb.IsEmpty() {
+		t.Fatalf("expect IsEmpty is true but got false")
+	}
+	New(0).Prefault()
+}
+
+func TestRingBuffer_ReadVectored(t *testing.T) {
+	rb := New(8)
+
+	if _, err := rb.ReadVectored(make([]byte, 4)); err != ErrIsEmpty {
+		t.Fatalf("expect ErrIsEmpty but got %v", err)
+	}
+
+	// make the data wrap: r=6, w=4
+	rb.Write([]byte("012345"))
+	rb.Read(make([]byte, 6))
+	rb.Write([]byte("abcdef"))
+
+	a, b, c := make([]byte, 3), make([]byte, 2), make([]byte, 4)
+	n, err := rb.ReadVectored(a, b, c)
+	if err != nil {
+		t.Fatalf("ReadVectored failed: %v", err)
+	}
+	if n != 6 {
+		t.Fatalf("expect read 6 bytes but got %d", n)
+	}
+	if string(a) != "abc" || string(b) != "de" || string(c[:1]) != "f" {
+		t.Fatalf("expect abc/de/f but got %s/%s/%s", a, b, c[:1])
+	}
+	if !rb.IsEmpty() {
+		t.Fatalf("expect IsEmpty is true but got false. r.w=%d, r.r=%d", rb.w, rb.r)
+	}
+}
+
+func TestRingBuffer_WriteSome(t *testing.T) {
+	rb := New(8)
+
+	n, err := rb.WriteSome([]byte("abcdef"))
+	if err != nil || n != 6 {
+		t.Fatalf("expect write 6 bytes but got %d, %v", n, err)
+	}
+	n, err = rb.WriteSome([]byte("ghijkl"))
+	if err != nil {
+		t.Fatalf("expect no error on a short write but got %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("expect write 2 bytes but got %d", n)
+	}
+	n, err = rb.WriteSome([]byte("ijkl"))
+	if err != ErrIsFull || n != 0 {
+		t.Fatalf("expect ErrIsFull but got %d, %v", n, err)
+	}
+	if string(rb.Bytes()) != "abcdefgh" {
+		t.Fatalf("expect abcdefgh but got %s", rb.Bytes())
+	}
+}
+
+func TestRingBuffer_WriteSizeHistogram(t *testing.T) {
+	rb := New(1024)
+	if rb.WriteSizeHistogram() != nil {
+		t.Fatalf("expect nil histogram when disabled")
+	}
+
+	rb.EnableWriteSizeHistogram([]int{64, 8})
+	for _, size := range []int{1, 8, 9, 64, 65, 500} {
+		rb.Write(make([]byte, size))
+	}
+	h := rb.WriteSizeHistogram()
+	if h[8] != 2 || h[64] != 2 || h[math.MaxInt] != 2 {
+		t.Fatalf("expect 2 writes in each bucket but got %v", h)
+	}
+
+	rb.EnableWriteSizeHistogram([]int{8, 64, 8, math.MaxInt})
+	for _, size := range []int{1, 8, 9, 500} {
+		rb.Write(make([]byte, size))
+	}
+	if h = rb.WriteSizeHistogram(); len(h) != 3 || h[8] != 2 || h[64] != 1 || h[math.MaxInt] != 1 {
+		t.Fatalf("expect duplicate bounds to count as one but got %v", h)
+	}
+}
+
+func TestRingBuffer_ReadUntil(t *testing.T) {
+	rb := New(16)
+
+	// make the separator wrap: r=10, "\r\n\r\n" spans the end of buf
+	rb.Write(make([]byte, 10))
+	rb.Read(make([]byte, 10))
+	rb.Write([]byte("GET /\r\n\r\nbody"))
+
+	if _, err := rb.ReadUntil([]byte("\n\n")); err != ErrIsEmpty {
+		t.Fatalf("expect ErrIsEmpty but got %v", err)
+	}
+	if rb.Length() != 13 {
+		t.Fatalf("expect len 13 bytes but got %d", rb.Length())
+	}
+
+	line, err := rb.ReadUntil([]byte("\r\n\r\n"))
+	if err != nil {
+		t.Fatalf("ReadUntil failed: %v", err)
+	}
+	if string(line) != "GET /" {
+		t.Fatalf("expect GET / but got %q", line)
+	}
+	if string(rb.Bytes()) != "body" {
+		t.Fatalf("expect body but got %q. r.w=%d, r.r=%d", rb.Bytes(), rb.w, rb.r)
+	}
+}
+
+func TestRingBuffer_Overwrite(t *testing.T) {
+	rb := New(8)
+	rb.SetOverwrite(true)
+
+	rb.Write([]byte("abcdef"))
+	n, err := rb.Write([]byte("ghij"))
+	if err != nil || n != 4 {
+		t.Fatalf("expect write 4 bytes but got %d, %v", n, err)
+	}
+	if string(rb.Bytes()) != "cdefghij" {
+		t.Fatalf("expect cdefghij but got %s. r.w=%d, r.r=%d", rb.Bytes(), rb.w, rb.r)
+	}
+	if rb.EvictedBytes() != 2 {
+		t.Fatalf("expect 2 evicted bytes but got %d", rb.EvictedBytes())
+	}
+
+	// larger than the whole buffer
+	n, err = rb.Write([]byte("0123456789"))
+	if err != nil || n != 10 {
+		t.Fatalf("expect write 10 bytes but got %d, %v", n, err)
+	}
+	if string(rb.Bytes()) != "23456789" || !rb.IsFull() {
+		t.Fatalf("expect full 23456789 but got %s. r.w=%d, r.r=%d", rb.Bytes(), rb.w, rb.r)
+	}
+	if rb.EvictedBytes() != 12 {
+		t.Fatalf("expect 12 evicted bytes but got %d", rb.EvictedBytes())
+	}
+}
+
+func TestRingBuffer_ZeroValue(t *testing.T) {
+	var rb RingBuffer
+
+	if _, err := rb.Write([]byte("abcd")); err != ErrIsFull {
+		t.Fatalf("expect ErrIsFull but got %v", err)
+	}
+	if err := rb.WriteByte('a'); err != ErrIsFull {
+		t.Fatalf("expect ErrIsFull but got %v", err)
+	}
+	if rb.IsFull() || !rb.IsEmpty() {
+		t.Fatalf("expect a zero value RingBuffer to be empty")
+	}
+
+	rb.Init(8)
+	n, err := rb.Write([]byte("abcd"))
+	if err != nil || n != 4 {
+		t.Fatalf("expect write 4 bytes but got %d, %v", n, err)
+	}
+	if rb.Capacity() != 8 || rb.Length() != 4 {
+		t.Fatalf("expect capacity 8 and len 4 but got %d and %d", rb.Capacity(), rb.Length())
+	}
+}
+
+func TestRingBuffer_AppendBuffer(t *testing.T) {
+	dst, src := New(8), New(8)
+
+	// make src wrap
+	src.Write([]byte("012345"))
+	src.Read(make([]byte, 5))
+	src.Write([]byte("abcdef"))
+
+	dst.Write([]byte("xy"))
+	n, err := dst.AppendBuffer(src)
+	if err != ErrTooManyDataToWrite {
+		t.Fatalf("expect ErrTooManyDataToWrite but got %v", err)
+	}
+	if n != 6 {
+		t.Fatalf("expect transfer 6 bytes but got %d", n)
+	}
+	if string(dst.Bytes()) != "xy5abcde" || string(src.Bytes()) != "f" {
+		t.Fatalf("expect xy5abcde and f but got %s and %s", dst.Bytes(), src.Bytes())
+	}
+
+	if _, err = dst.AppendBuffer(dst); err != ErrSameBuffer {
+		t.Fatalf("expect ErrSameBuffer but got %v", err)
+	}
+}
+
+func TestRingBuffer_ReadWhile(t *testing.T) {
+	rb := New(8)
+	isDigit := func(b byte) bool { return b >= '0' && b <= '9' }
+
+	// make the digits wrap
+	rb.Write([]byte("xxxxx"))
+	rb.Read(make([]byte, 5))
+	rb.Write([]byte("12345+6"))
+
+	if p := rb.ReadWhile(isDigit); string(p) != "12345" {
+		t.Fatalf("expect 12345 but got %s", p)
+	}
+	if p := rb.ReadWhile(isDigit); p != nil {
+		t.Fatalf("expect nil but got %s", p)
+	}
+	rb.ReadByte()
+	if p := rb.ReadWhile(isDigit); string(p) != "6" {
+		t.Fatalf("expect 6 but got %s", p)
+	}
+	if !rb.IsEmpty() {
+		t.Fatalf("expect IsEmpty is true but got false")
+	}
+}
+
+func TestRingBuffer_NewPow2(t *testing.T) {
+	rb := NewPow2(100)
+	if rb.Capacity() != 128 {
+		t.Fatalf("expect capacity 128 but got %d", rb.Capacity())
+	}
+	if NewPow2(64).Capacity() != 64 || NewPow2(0).Capacity() != 1 {
+		t.Fatalf("expect exact powers of two to be kept")
+	}
+
+	rb.Write(make([]byte, 100))
+	rb.Read(make([]byte, 100))
+	rb.Write([]byte(strings.Repeat("abcd", 16)))
+	buf := make([]byte, 64)
+	if n, _ := rb.Read(buf); n != 64 || string(buf) != strings.Repeat("abcd", 16) {
+		t.Fatalf("expect 16 abcd but got %s", buf[:n])
+	}
+	if rb.r != 36 {
+		t.Fatalf("expect r.r=36 but got %d", rb.r)
+	}
+}
+
+func TestRingBuffer_Splice(t *testing.T) {
+	dst, src := New(8), New(8)
+
+	// both sides wrap
+	src.Write([]byte("012345"))
+	src.Read(make([]byte, 5))
+	src.Write([]byte("abcdef"))
+	dst.Write([]byte("xxxxxx"))
+	dst.Read(make([]byte, 6))
+
+	n, err := dst.Splice(src, 5)
+	if err != nil || n != 5 {
+		t.Fatalf("expect splice 5 bytes but got %d, %v", n, err)
+	}
+	if string(dst.Bytes()) != "5abcd" || string(src.Bytes()) != "ef" {
+		t.Fatalf("expect 5abcd and ef but got %s and %s. r.w=%d, r.r=%d", dst.Bytes(), src.Bytes(), dst.w, dst.r)
+	}
+
+	src.Reset()
+	if _, err = dst.Splice(src, 5); err != ErrIsEmpty {
+		t.Fatalf("expect ErrIsEmpty but got %v", err)
+	}
+}
+
+func TestRingBuffer_MarshalJSON(t *testing.T) {
+	rb := New(8)
+	rb.Write([]byte("abc"))
+
+	data, err := json.Marshal(rb)
+	if err != nil {
+		t.Fatalf("MarshalJSON failed: %v", err)
+	}
+	expected := `{"capacity":8,"length":3,"free":5,"full":false,"closed":false,"read_pos":0,"write_pos":3}`
+	if string(data) != expected {
+		t.Fatalf("expect %s but got %s", expected, data)
+	}
+
+	rb.SetJSONIncludeData(true)
+	data, _ = json.Marshal(rb)
+	if !strings.HasSuffix(string(data), `"write_pos":3,"data":"YWJj"}`) {
+		t.Fatalf("expect base64 data but got %s", data)
+	}
+}
+
+func TestRingBuffer_ReadPooled(t *testing.T) {
+	rb := New(16)
+	if _, err := rb.ReadPooled(8); err != ErrIsEmpty {
+		t.Fatalf("expect ErrIsEmpty but got %v", err)
+	}
+
+	var pool sync.Pool
+	rb.SetReadPool(&pool)
+	rb.Write([]byte("abcdefghij"))
+
+	b, err := rb.ReadPooled(8)
+	if err != nil {
+		t.Fatalf("ReadPooled failed: %v", err)
+	}
+	if string(b.Bytes()) != "abcdefgh" || b.Len() != 8 {
+		t.Fatalf("expect abcdefgh but got %s", b.Bytes())
+	}
+	b.Retain()
+	b.Release()
+	if string(b.Bytes()) != "abcdefgh" {
+		t.Fatalf("expect bytes valid while retained")
+	}
+	b.Release()
+
+	if b, err = rb.ReadPooled(-1); err != nil || string(b.Bytes()) != "ij" {
+		t.Fatalf("expect a negative max to read everything but got %v", err)
+	}
+	b.Release()
+
+	// pool 里没有时按读到的长度分配，而不是按 max
+	rb.SetReadPool(&sync.Pool{})
+	rb.Write([]byte("kl"))
+	if b, _ = rb.ReadPooled(1 << 20); string(b.Bytes()) != "kl" || cap(b.Bytes()) != 2 {
+		t.Fatalf("expect kl in a slice of 2 bytes but got %q of %d", b.Bytes(), cap(b.Bytes()))
+	}
+	b.Release()
+}
+
+func TestRingBuffer_ReadFullBlocking(t *testing.T) {
+	rb := New(4)
+
+	go func() {
+		for _, s := range []string{"ab", "cd", "ef"} {
+			time.Sleep(10 * time.Millisecond)
+			rb.Write([]byte(s))
+		}
+		time.Sleep(10 * time.Millisecond)
+		rb.Close()
+	}()
+
+	buf := make([]byte, 3)
+	n, err := rb.ReadFullBlocking(buf)
+	if err != nil || n != 3 || string(buf) != "abc" {
+		t.Fatalf("expect abc but got %s, %v", buf[:n], err)
+	}
+	buf = make([]byte, 4)
+	n, err = rb.ReadFullBlocking(buf)
+	if err != io.ErrUnexpectedEOF || string(buf[:n]) != "def" {
+		t.Fatalf("expect def and io.ErrUnexpectedEOF but got %s, %v", buf[:n], err)
+	}
+	n, err = rb.ReadFullBlocking(buf)
+	if err != io.EOF || n != 0 {
+		t.Fatalf("expect io.EOF but got %d, %v", n, err)
+	}
+}
+
+func TestPool(t *testing.T) {
+	p := &Pool{Zero: true}
+
+	rb := p.Get(16)
+	if rb.Capacity() != 16 || !rb.IsEmpty() {
+		t.Fatalf("expect an empty buffer of 16 bytes")
+	}
+	rb.Write([]byte("secret"))
+	rb.SetOverwrite(true)
+	rb.Close()
+	buf := rb.buf
+	p.Put(rb)
+
+	if !bytes.Equal(buf, make([]byte, 16)) {
+		t.Fatalf("expect data zeroed on Put but got %q", buf)
+	}
+
+	rb = p.Get(16)
+	if rb.Capacity() != 16 || !rb.IsEmpty() {
+		t.Fatalf("expect an empty buffer of 16 bytes")
+	}
+	if _, err := rb.Write([]byte("abcd")); err != nil {
+		t.Fatalf("expect recycled buffer writable but got %v", err)
+	}
+	if p.Get(32).Capacity() != 32 {
+		t.Fatalf("expect a buffer of 32 bytes")
+	}
+}
+
+func TestRingBuffer_WriteReporting(t *testing.T) {
+	rb := New(4)
+
+	n, overflow, err := rb.WriteReporting([]byte("abcdef"))
+	if err != ErrTooManyDataToWrite || n != 4 || string(overflow) != "ef" {
+		t.Fatalf("expect 4 bytes written and ef overflow but got %d, %q, %v", n, overflow, err)
+	}
+	n, overflow, err = rb.WriteReporting([]byte("gh"))
+	if err != ErrIsFull || n != 0 || string(overflow) != "gh" {
+		t.Fatalf("expect gh overflow but got %d, %q, %v", n, overflow, err)
+	}
+	rb.Reset()
+	if _, overflow, err = rb.WriteReporting([]byte("ij")); err != nil || overflow != nil {
+		t.Fatalf("expect no overflow but got %q, %v", overflow, err)
+	}
+}
+
+func TestRingBuffer_SetSoftLimit(t *testing.T) {
+	rb := New(8)
+	rb.SetSoftLimit(6)
+
+	if rb.Free() != 6 {
+		t.Fatalf("expect free 6 bytes but got %d", rb.Free())
+	}
+	n, err := rb.Write([]byte("abcdefgh"))
+	if err != ErrTooManyDataToWrite || n != 6 {
+		t.Fatalf("expect write 6 bytes but got %d, %v", n, err)
+	}
+	if !rb.IsFull() || rb.Free() != 0 || rb.Length() != 6 || rb.Capacity() != 8 {
+		t.Fatalf("expect soft full with len 6 but got len %d, free %d", rb.Length(), rb.Free())
+	}
+	if err = rb.WriteByte('x'); err != ErrIsFull {
+		t.Fatalf("expect ErrIsFull but got %v", err)
+	}
+
+	rb.SetSoftLimit(0)
+	if rb.IsFull() || rb.Free() != 2 {
+		t.Fatalf("expect free 2 bytes without a limit but got %d", rb.Free())
+	}
+}
+
+func TestRingBuffer_ResetReadWrite(t *testing.T) {
+	rb := New(8)
+
+	// wrapped data is moved to the start
+	rb.Write([]byte("xxxxx"))
+	rb.Read(make([]byte, 5))
+	rb.Write([]byte("abcdef"))
+	rb.ResetRead()
+	if rb.r != 0 || rb.w != 6 || string(rb.Bytes()) != "abcdef" {
+		t.Fatalf("expect abcdef at 0 but got %s. r.w=%d, r.r=%d", rb.Bytes(), rb.w, rb.r)
+	}
+
+	// contiguous data, and a full buffer
+	rb.Read(make([]byte, 2))
+	rb.Write([]byte("ghij"))
+	rb.Read(make([]byte, 1))
+	rb.ResetRead()
+	if rb.r != 0 || rb.w != 7 || string(rb.Bytes()) != "defghij" {
+		t.Fatalf("expect defghij at 0 but got %s. r.w=%d, r.r=%d", rb.Bytes(), rb.w, rb.r)
+	}
+	rb.WriteByte('k')
+	rb.ReadByte()
+	rb.WriteByte('l')
+	rb.ResetRead()
+	if rb.r != 0 || rb.w != 0 || !rb.IsFull() || string(rb.Bytes()) != "efghijkl" {
+		t.Fatalf("expect full efghijkl at 0 but got %s. r.w=%d, r.r=%d", rb.Bytes(), rb.w, rb.r)
+	}
+
+	rb.Read(make([]byte, 3))
+	rb.ResetWrite()
+	if !rb.IsEmpty() || rb.r != 3 || rb.w != 3 {
+		t.Fatalf("expect empty at 3 but got %s. r.w=%d, r.r=%d", rb.Bytes(), rb.w, rb.r)
+	}
+}
+
+func TestRingBuffer_Index(t *testing.T) {
+	rb := New(8)
+	rb.Write([]byte("xxxxx"))
+	rb.Read(make([]byte, 5))
+	rb.Write([]byte("ab\r\ncd\r\n"))
+
+	cases := []struct {
+		sep string
+		idx int
+	}{
+		{"\r\n", 2},
+		{"b\r\nc", 1},
+		{"cd", 4},
+		{"d\r\n", 5},
+		{"ab\r\ncd\r\n", 0},
+		{"\n\n", -1},
+		{"", 0},
+	}
+	for _, c := range cases {
+		if idx := rb.Index([]byte(c.sep)); idx != c.idx {
+			t.Fatalf("expect index of %q is %d but got %d", c.sep, c.idx, idx)
+		}
+	}
+	if rb.Length() != 8 {
+		t.Fatalf("expect Index not to consume")
+	}
+}
+
+func TestRingBuffer_SetWriteRateLimit(t *testing.T) {
+	rb := New(4096)
+	rb.SetWriteRateLimit(1000)
+
+	// the first second worth of bytes is a burst, the next 100 bytes take about 100ms
+	start := time.Now()
+	n, err := rb.WriteBlocking(make([]byte, 1100))
+	if err != nil || n != 1100 {
+		t.Fatalf("expect write 1100 bytes but got %d, %v", n, err)
+	}
+	if d := time.Since(start); d < 80*time.Millisecond {
+		t.Fatalf("expect the write to be paced but it took %v", d)
+	}
+
+	// cancellation is prompt while paced
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = rb.WriteContext(ctx, make([]byte, 1000))
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expect context.DeadlineExceeded but got %v", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("expect prompt cancellation but it took %v", d)
+	}
+}
+
+func TestRingBuffer_WriteBlocking(t *testing.T) {
+	rb := New(4)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		n, err := rb.WriteBlocking([]byte("abcdefghij"))
+		if err != nil || n != 10 {
+			t.Errorf("expect write 10 bytes but got %d, %v", n, err)
+		}
+	}()
+
+	var got []byte
+	for len(got) < 10 {
+		b, err := rb.ReadByteContext(context.Background())
+		if err != nil {
+			t.Fatalf("ReadByteContext failed: %v", err)
+		}
+		got = append(got, b)
+	}
+	<-done
+	if string(got) != "abcdefghij" {
+		t.Fatalf("expect abcdefghij but got %s", got)
+	}
+
+	rb.Write([]byte("abcd"))
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		rb.Close()
+	}()
+	if _, err := rb.WriteBlocking([]byte("e")); err != ErrIsClosed {
+		t.Fatalf("expect ErrIsClosed but got %v", err)
+	}
+}
+
+func TestRingBuffer_CountByte(t *testing.T) {
+	rb := New(8)
+	rb.Write([]byte("xxxxx"))
+	rb.Read(make([]byte, 5))
+	rb.Write([]byte("a\nb\nc\nd"))
+
+	if n := rb.CountByte('\n'); n != 3 {
+		t.Fatalf("expect 3 newlines but got %d", n)
+	}
+	if n := rb.CountByte('x'); n != 0 {
+		t.Fatalf("expect 0 x but got %d", n)
+	}
+}
+
+func TestRingBuffer_Tail(t *testing.T) {
+	rb := New(8)
+	if rb.Tail(4) != nil {
+		t.Fatalf("expect nil tail of an empty buffer")
+	}
+
+	rb.Write([]byte("xxxxx"))
+	rb.Read(make([]byte, 5))
+	rb.Write([]byte("abcdef"))
+
+	if p := rb.Tail(2); string(p) != "ef" {
+		t.Fatalf("expect ef but got %s", p)
+	}
+	if p := rb.Tail(5); string(p) != "bcdef" {
+		t.Fatalf("expect bcdef but got %s", p)
+	}
+	if p := rb.Tail(100); string(p) != "abcdef" {
+		t.Fatalf("expect abcdef but got %s", p)
+	}
+	if rb.Length() != 6 {
+		t.Fatalf("expect Tail not to consume")
+	}
+}
+
+func TestRingBuffer_ConsumePrefix(t *testing.T) {
+	rb := New(8)
+	rb.Write([]byte("xxxxx"))
+	rb.Read(make([]byte, 5))
+	rb.Write([]byte("HELLO!"))
+
+	if rb.ConsumePrefix([]byte("HELP")) {
+		t.Fatalf("expect mismatch")
+	}
+	if rb.ConsumePrefix([]byte("HELLO!!")) {
+		t.Fatalf("expect no match when not enough bytes are buffered")
+	}
+	if !rb.ConsumePrefix([]byte("HELLO")) {
+		t.Fatalf("expect match across the wrap")
+	}
+	if string(rb.Bytes()) != "!" {
+		t.Fatalf("expect ! but got %s", rb.Bytes())
+	}
+}
+
+func TestRingBuffer_ReadNoBlock(t *testing.T) {
+	rb := New(16)
+	rb.Write([]byte("abcdefgh"))
+
+	buf := make([]byte, 16)
+	n, err := rb.ReadNoBlock(buf, 3)
+	if err != nil || n != 3 || string(buf[:n]) != "abc" {
+		t.Fatalf("expect abc but got %s, %v", buf[:n], err)
+	}
+	n, _ = rb.ReadNoBlock(buf, 0)
+	if n != 5 || string(buf[:n]) != "defgh" {
+		t.Fatalf("expect defgh but got %s", buf[:n])
+	}
+
+	// 阻塞模式下也不等数据
+	rb.SetBlocking(true)
+	if n, err = rb.ReadNoBlock(buf, 0); n != 0 || err != ErrIsEmpty {
+		t.Fatalf("expect ErrIsEmpty in blocking mode but got %d, %v", n, err)
+	}
+	rb = New(0)
+	rb.SetBlocking(true)
+	if n, err = rb.ReadNoBlock(buf, 0); n != 0 || err != ErrIsEmpty {
+		t.Fatalf("expect ErrIsEmpty on a zero size buffer but got %d, %v", n, err)
+	}
+}
+
+func TestRingBuffer_BlockingOverwrite(t *testing.T) {
+	rb := New(4)
+	rb.SetOverwrite(true)
+	rb.SetBlocking(true)
+	done := make(chan error, 1)
+	go func() {
+		_, err := rb.Write([]byte("abcdef"))
+		if err == nil {
+			_, err = rb.WriteContext(context.Background(), []byte("gh"))
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expect overwrite writes to succeed but got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expect blocking writes not to wait for space in overwrite mode")
+	}
+	if string(rb.Bytes()) != "efgh" {
+		t.Fatalf("expect efgh but got %q", rb.Bytes())
+	}
+}
+
+func TestCoalescingWriter(t *testing.T) {
+	rb := New(64)
+	cw := NewCoalescingWriter(rb, 8, 30*time.Millisecond)
+
+	cw.Write([]byte("abc"))
+	cw.WriteByte('d')
+	if !rb.IsEmpty() {
+		t.Fatalf("expect staged bytes not visible yet")
+	}
+	cw.Write([]byte("efgh"))
+	if string(rb.Bytes()) != "abcdefgh" {
+		t.Fatalf("expect flush at threshold but got %s", rb.Bytes())
+	}
+
+	cw.Write([]byte("ij"))
+	time.Sleep(100 * time.Millisecond)
+	if string(rb.Bytes()) != "abcdefghij" {
+		t.Fatalf("expect flush on interval but got %s", rb.Bytes())
+	}
+
+	cw.Write([]byte("kl"))
+	if err := cw.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if string(rb.Bytes()) != "abcdefghijkl" {
+		t.Fatalf("expect flush on close but got %s", rb.Bytes())
+	}
+	if _, err := cw.Write([]byte("m")); err != ErrIsClosed {
+		t.Fatalf("expect ErrIsClosed but got %v", err)
+	}
+
+	// interval <= 0 不启动定时 flush，threshold <= 0 每次都 flush
+	rb = New(8)
+	cw = NewCoalescingWriter(rb, 4, 0)
+	cw.Write([]byte("ab"))
+	time.Sleep(10 * time.Millisecond)
+	if !rb.IsEmpty() {
+		t.Fatalf("expect no timed flush but got %q", rb.Bytes())
+	}
+	cw.Close()
+	if string(rb.Bytes()) != "ab" {
+		t.Fatalf("expect flush on close but got %q", rb.Bytes())
+	}
+	cw = NewCoalescingWriter(rb, -1, 0)
+	cw.Write([]byte("c"))
+	if string(rb.Bytes()) != "abc" {
+		t.Fatalf("expect a flush on every write but got %q", rb.Bytes())
+	}
+	cw.Close()
+}
+
+func TestRingBuffer_BytesNoCopy(t *testing.T) {
+	rb := New(8)
+	rb.Write([]byte("xxxabc"))
+	rb.Read(make([]byte, 3))
+
+	p, view := rb.BytesNoCopy()
+	if !view || string(p) != "abc" || &p[0] != &rb.buf[3] {
+		t.Fatalf("expect a view of abc but got %s, %v", p, view)
+	}
+
+	rb.Write([]byte("defg"))
+	p, view = rb.BytesNoCopy()
+	if view || string(p) != "abcdefg" {
+		t.Fatalf("expect a copy of abcdefg but got %s, %v", p, view)
+	}
+}
+
+func TestRingBuffer_PeekFunc(t *testing.T) {
+	rb := New(8)
+	rb.Write([]byte("xxxxx"))
+	rb.Read(make([]byte, 5))
+	rb.Write([]byte("abcdef"))
+
+	var got1, got2 string
+	peek := func(seg1, seg2 []byte) {
+		got1, got2 = string(seg1), string(seg2)
+	}
+	rb.PeekFunc(2, peek)
+	if got1 != "ab" || got2 != "" {
+		t.Fatalf("expect ab but got %s/%s", got1, got2)
+	}
+	rb.PeekFunc(5, peek)
+	if got1 != "abc" || got2 != "de" {
+		t.Fatalf("expect abc/de but got %s/%s", got1, got2)
+	}
+	rb.PeekFunc(100, peek)
+	if got1 != "abc" || got2 != "def" {
+		t.Fatalf("expect abc/def but got %s/%s", got1, got2)
+	}
+	if rb.Length() != 6 {
+		t.Fatalf("expect PeekFunc not to consume")
+	}
+}
+
+func TestRingBuffer_OldestAge(t *testing.T) {
+	rb := New(16)
+	rb.Write([]byte("ab"))
+	if rb.OldestAge() != 0 {
+		t.Fatalf("expect 0 age when tracking is off")
+	}
+
+	rb.SetAgeTracking(true)
+	time.Sleep(30 * time.Millisecond)
+	rb.Write([]byte("cd"))
+	if age := rb.OldestAge(); age < 30*time.Millisecond {
+		t.Fatalf("expect age of at least 30ms but got %v", age)
+	}
+
+	// drop the first write, the oldest byte is now from the second one
+	rb.Read(make([]byte, 3))
+	if age := rb.OldestAge(); age >= 30*time.Millisecond {
+		t.Fatalf("expect age below 30ms but got %v", age)
+	}
+	if len(rb.stamps) != 1 || rb.stamps[0].n != 1 {
+		t.Fatalf("expect one stamp of 1 byte but got %v", rb.stamps)
+	}
+
+	rb.ReadByte()
+	if rb.OldestAge() != 0 {
+		t.Fatalf("expect 0 age of an empty buffer")
+	}
+}
+
+func TestRingBuffer_WriteSeq(t *testing.T) {
+	rb := New(8)
+
+	n, seq, err := rb.WriteSeq([]byte("abc"))
+	if err != nil || n != 3 || seq != 0 {
+		t.Fatalf("expect seq 0 but got %d, %d, %v", n, seq, err)
+	}
+	rb.WriteByte('d')
+	rb.Read(make([]byte, 4))
+	n, seq, err = rb.WriteSeq([]byte("efghijkl"))
+	if err != nil || n != 8 || seq != 4 {
+		t.Fatalf("expect seq 4 but got %d, %d, %v", n, seq, err)
+	}
+	if _, seq, _ = rb.WriteSeq([]byte("m")); seq != 12 {
+		t.Fatalf("expect seq 12 but got %d", seq)
+	}
+}
+
+func TestRingBuffer_ReadOffset(t *testing.T) {
+	rb := New(8)
+
+	rb.Write([]byte("abcdef"))
+	rb.Read(make([]byte, 2))
+	rb.ReadByte()
+	if off := rb.ReadOffset(); off != 3 {
+		t.Fatalf("expect read offset 3 but got %d", off)
+	}
+
+	rb.Reset()
+	if off := rb.ReadOffset(); off != 6 {
+		t.Fatalf("expect read offset 6 after reset but got %d", off)
+	}
+
+	// evicted bytes are skipped too
+	rb.SetOverwrite(true)
+	rb.Write([]byte("0123456789"))
+	_, seq, _ := rb.WriteSeq(nil)
+	if off := rb.ReadOffset(); off != 8 || seq-off != uint64(rb.Length()) {
+		t.Fatalf("expect read offset 8 but got %d, next write at %d", off, seq)
+	}
+}
+
+func TestRingBuffer_ZeroSizeRendezvous(t *testing.T) {
+	rb := New(0)
+
+	if _, err := rb.Write([]byte("a")); err != ErrIsFull {
+		t.Fatalf("expect ErrIsFull but got %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		n, err := rb.WriteBlocking([]byte("hello"))
+		if err != nil || n != 5 {
+			t.Errorf("expect hand off 5 bytes but got %d, %v", n, err)
+		}
+	}()
+
+	buf := make([]byte, 3)
+	n, err := rb.ReadBlocking(buf)
+	if err != nil || string(buf[:n]) != "hel" {
+		t.Fatalf("expect hel but got %s, %v", buf[:n], err)
+	}
+	select {
+	case <-done:
+		t.Fatalf("expect the writer to block until all bytes are taken")
+	case <-time.After(20 * time.Millisecond):
+	}
+	b, _ := rb.ReadByteContext(context.Background())
+	n, _ = rb.ReadBlocking(buf)
+	if b != 'l' || string(buf[:n]) != "o" {
+		t.Fatalf("expect l and o but got %c and %s", b, buf[:n])
+	}
+	<-done
+
+	go rb.WriteBlocking([]byte("full"))
+	buf = make([]byte, 4)
+	if n, err = rb.ReadFullBlocking(buf); err != nil || string(buf) != "full" {
+		t.Fatalf("expect full but got %s, %v", buf[:n], err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if n, err = rb.WriteContext(ctx, []byte("x")); err != context.DeadlineExceeded || n != 0 {
+		t.Fatalf("expect context.DeadlineExceeded but got %d, %v", n, err)
+	}
+	rb.Close()
+	if _, err = rb.ReadBlocking(buf); err != ErrIsClosed {
+		t.Fatalf("expect ErrIsClosed but got %v", err)
+	}
+}
+
+func TestRingBuffer_WaitFree(t *testing.T) {
+	rb := New(8)
+	rb.Write([]byte("abcdef"))
+
+	if err := rb.WaitFree(9, context.Background()); err != ErrTooManyDataToWrite {
+		t.Fatalf("expect ErrTooManyDataToWrite but got %v", err)
+	}
+	if err := rb.WaitFree(2, context.Background()); err != nil {
+		t.Fatalf("expect 2 free bytes but got %v", err)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		rb.Read(make([]byte, 3))
+	}()
+	if err := rb.WaitFree(5, context.Background()); err != nil {
+		t.Fatalf("WaitFree failed: %v", err)
+	}
+	if rb.Free() < 5 {
+		t.Fatalf("expect at least 5 free bytes but got %d", rb.Free())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := rb.WaitFree(8, ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expect context.DeadlineExceeded but got %v", err)
+	}
+}
+
+func TestConsumer(t *testing.T) {
+	rb := New(8)
+	c := rb.NewConsumer()
+
+	go func() {
+		rb.WriteBlocking([]byte("abcdefghij"))
+		rb.Close()
+	}()
+
+	var got []byte
+	for {
+		chunk, err := c.Next(3)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		if len(chunk) > 3 {
+			t.Fatalf("expect at most 3 bytes but got %d", len(chunk))
+		}
+		got = append(got, chunk...)
+	}
+	if string(got) != "abcdefghij" {
+		t.Fatalf("expect abcdefghij but got %s", got)
+	}
+
+	c = New(8).NewConsumer()
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		c.Close()
+	}()
+	if _, err := c.Next(3); err != ErrIsClosed {
+		t.Fatalf("expect ErrIsClosed but got %v", err)
+	}
+
+	rb = New(8)
+	rb.Write([]byte("abc"))
+	c = rb.NewConsumer()
+	if chunk, err := c.Next(0); err != nil || string(chunk) != "abc" {
+		t.Fatalf("expect max 0 to read everything but got %q %v", chunk, err)
+	}
+	rb.Destroy()
+	if _, err := c.Next(3); err != ErrDestroyed {
+		t.Fatalf("expect ErrDestroyed but got %v", err)
+	}
+}
+
+func TestRingBuffer_PauseWrites(t *testing.T) {
+	rb := New(8)
+	rb.PauseWrites()
+	if _, err := rb.Write([]byte("abc")); err != ErrPaused {
+		t.Fatalf("expect ErrPaused but got %v", err)
+	}
+	if err := rb.WriteByte('a'); err != ErrPaused {
+		t.Fatalf("expect ErrPaused but got %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := rb.WriteBlocking([]byte("abc"))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("expect WriteBlocking to wait while paused but it returned %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	rb.ResumeWrites()
+	if err := <-done; err != nil {
+		t.Fatalf("WriteBlocking failed: %v", err)
+	}
+	if rb.Length() != 3 {
+		t.Fatalf("expect length 3 but got %d", rb.Length())
+	}
+}
+
+func TestRingBuffer_SetStallCallback(t *testing.T) {
+	rb := New(16)
+	stalled := make(chan int, 1)
+	rb.SetStallCallback(30*time.Millisecond, func(n int) { stalled <- n })
+
+	rb.Write([]byte("abcd"))
+	// 持续读取时不应该触发
+	for i := 0; i < 3; i++ {
+		time.Sleep(15 * time.Millisecond)
+		rb.ReadByte()
+	}
+	select {
+	case n := <-stalled:
+		t.Fatalf("expect no stall while reading but got one with %d bytes", n)
+	default:
+	}
+
+	select {
+	case n := <-stalled:
+		if n != 1 {
+			t.Fatalf("expect 1 buffered byte but got %d", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expect the stall callback to fire")
+	}
+
+	rb.Close()
+	rb.mu.Lock()
+	stopped := rb.stallTimer == nil
+	rb.mu.Unlock()
+	if !stopped {
+		t.Fatalf("expect Close to stop the stall timer")
+	}
+}
+
+type shortWriter struct {
+	max int
+	buf bytes.Buffer
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if len(p) > w.max {
+		p = p[:w.max]
+	}
+	return w.buf.Write(p)
+}
+
+func TestRingBuffer_FlushTo(t *testing.T) {
+	rb := New(8)
+	rb.Write([]byte("abcdef"))
+	rb.Read(make([]byte, 4))
+	rb.Write([]byte("ghijk")) // 数据跨越终点
+
+	if !rb.FlushPending() {
+		t.Fatalf("expect a pending flush")
+	}
+	var out bytes.Buffer
+	n, err := rb.FlushTo(&out)
+	if err != nil || n != 7 || out.String() != "efghijk" {
+		t.Fatalf("expect 7 bytes efghijk but got %d %q %v", n, out.String(), err)
+	}
+	if rb.FlushPending() {
+		t.Fatalf("expect no pending flush")
+	}
+	if n, err = rb.FlushTo(&out); n != 0 || err != nil {
+		t.Fatalf("expect an empty flush to succeed but got %d %v", n, err)
+	}
+
+	rb.Write([]byte("abcdef"))
+	w := &shortWriter{max: 4}
+	n, err = rb.FlushTo(w)
+	if err != io.ErrShortWrite || n != 4 || w.buf.String() != "abcd" {
+		t.Fatalf("expect a short write of abcd but got %d %q %v", n, w.buf.String(), err)
+	}
+	if string(rb.Bytes()) != "ef" {
+		t.Fatalf("expect ef to stay buffered but got %q", rb.Bytes())
+	}
+}
+
+func TestRingBuffer_ShrinkTo(t *testing.T) {
+	rb := New(16)
+	rb.Write([]byte("0123456789abcd"))
+	rb.Read(make([]byte, 12))
+	rb.Write([]byte("efgh")) // 数据跨越终点
+
+	if err := rb.ShrinkTo(5); err != ErrTooManyDataToWrite {
+		t.Fatalf("expect ErrTooManyDataToWrite but got %v", err)
+	}
+	if err := rb.ShrinkTo(6); err != nil {
+		t.Fatalf("ShrinkTo failed: %v", err)
+	}
+	if rb.Capacity() != 6 || !rb.IsFull() || string(rb.Bytes()) != "cdefgh" {
+		t.Fatalf("expect a full buffer of 6 bytes holding cdefgh but got %d %v %q", rb.Capacity(), rb.IsFull(), rb.Bytes())
+	}
+
+	rb.Read(make([]byte, 2))
+	if err := rb.ShrinkTo(4); err != nil {
+		t.Fatalf("ShrinkTo failed: %v", err)
+	}
+	if _, err := rb.Write([]byte("x")); err != ErrIsFull {
+		t.Fatalf("expect ErrIsFull but got %v", err)
+	}
+	rb.Read(make([]byte, 1))
+	rb.Write([]byte("x"))
+	if string(rb.Bytes()) != "fghx" {
+		t.Fatalf("expect fghx but got %q", rb.Bytes())
+	}
+
+	rb = NewWithAllocator(8, func(n int) []byte { return make([]byte, n) })
+	rb.SetFreeHook(func([]byte) error { return nil })
+	if err := rb.ShrinkTo(4); err != ErrNotSupported {
+		t.Fatalf("expect ErrNotSupported but got %v", err)
+	}
+}
+
+func TestRingBuffer_FullnessEvents(t *testing.T) {
+	rb := New(4)
+	events := rb.FullnessEvents()
+
+	rb.Write([]byte("abcd"))
+	if full := <-events; !full {
+		t.Fatalf("expect a full event")
+	}
+
+	rb.ReadByte()
+	rb.WriteByte('e')
+	rb.ReadByte()
+	// 接收方来不及时只保留最新的状态
+	select {
+	case full := <-events:
+		if full {
+			t.Fatalf("expect a not-full event")
+		}
+	default:
+		t.Fatalf("expect a pending event")
+	}
+	select {
+	case full := <-events:
+		t.Fatalf("expect coalesced events but got another %v", full)
+	default:
+	}
+
+	rb.Close()
+	if _, ok := <-events; ok {
+		t.Fatalf("expect the channel to be closed")
+	}
+}
+
+func TestRingBuffer_ReadFrame(t *testing.T) {
+	rb := New(16)
+	if err := rb.WriteFrame(2, true, []byte("hello")); err != nil {
+		t.Fatalf("WriteFrame failed: %v", err)
+	}
+	if err := rb.WriteFrame(2, true, make([]byte, 10)); err != ErrIsFull {
+		t.Fatalf("expect ErrIsFull but got %v", err)
+	}
+	if err := rb.WriteFrame(1, false, make([]byte, 256)); err != ErrFrameTooLarge {
+		t.Fatalf("expect ErrFrameTooLarge but got %v", err)
+	}
+	p, err := rb.ReadFrame(2, true)
+	if err != nil || string(p) != "hello" {
+		t.Fatalf("expect hello but got %q %v", p, err)
+	}
+
+	// 只写了一部分的 frame 不会被消费
+	rb.Write([]byte{0, 4, 'a', 'b'})
+	if _, err = rb.ReadFrame(2, true); err != ErrIsEmpty {
+		t.Fatalf("expect ErrIsEmpty but got %v", err)
+	}
+	rb.Write([]byte("cd"))
+	if p, err = rb.ReadFrame(2, true); err != nil || string(p) != "abcd" {
+		t.Fatalf("expect abcd but got %q %v", p, err)
+	}
+
+	rb.SetMaxFrameSize(8)
+	rb.Write([]byte{0, 9})
+	if _, err = rb.ReadFrame(2, true); err != ErrFrameTooLarge {
+		t.Fatalf("expect ErrFrameTooLarge but got %v", err)
+	}
+	if rb.Length() != 2 {
+		t.Fatalf("expect the prefix to stay buffered but got length %d", rb.Length())
+	}
+	rb.Reset()
+	rb.SetMaxFrameSize(0)
+	rb.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	if _, err = rb.ReadFrame(4, false); err != ErrFrameTooLarge {
+		t.Fatalf("expect ErrFrameTooLarge for a frame larger than the buffer but got %v", err)
+	}
+}
+
+func TestRingBuffer_DestroyedHelpers(t *testing.T) {
+	rb := New(16)
+	rb.Write([]byte("ab\n"))
+	rb.Destroy()
+
+	errs := map[string]error{}
+	_, errs["ReadUntil"] = rb.ReadUntil([]byte("\n"))
+	_, errs["ReadLine"] = rb.ReadLine()
+	_, errs["ReadLines"] = rb.ReadLines(2)
+	_, errs["ReadVectored"] = rb.ReadVectored(make([]byte, 2))
+	_, errs["ReadPooled"] = rb.ReadPooled(2)
+	_, errs["ReadFrame"] = rb.ReadFrame(2, true)
+	_, errs["ReadAllFrames"] = rb.ReadAllFrames(2, true, 2)
+	errs["WriteFull"] = rb.WriteFull([]byte("a"))
+	_, errs["WriteStringTruncateRune"] = rb.WriteStringTruncateRune("a")
+	for name, err := range errs {
+		if err != ErrDestroyed {
+			t.Fatalf("expect %s to return ErrDestroyed but got %v", name, err)
+		}
+	}
+}
+
+func TestRingBuffer_DestroyTwice(t *testing.T) {
+	rb := NewWithAllocator(8, func(n int) []byte { return make([]byte, n) })
+	freed := 0
+	rb.SetFreeHook(func([]byte) error {
+		freed++
+		return nil
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := rb.ReadBlocking(make([]byte, 1))
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	if err := rb.Destroy(); err != nil {
+		t.Fatalf("Destroy failed: %v", err)
+	}
+	if err := <-done; err != ErrDestroyed {
+		t.Fatalf("expect the blocked reader to get ErrDestroyed but got %v", err)
+	}
+	if err := rb.Destroy(); err != nil || freed != 1 {
+		t.Fatalf("expect a second Destroy to be a no-op but got %v and %d frees", err, freed)
+	}
+	if _, err := rb.Write([]byte("a")); err != ErrDestroyed {
+		t.Fatalf("expect ErrDestroyed but got %v", err)
+	}
+	if _, err := rb.Read(make([]byte, 1)); err != ErrDestroyed {
+		t.Fatalf("expect ErrDestroyed but got %v", err)
+	}
+	if _, err := rb.ReadByte(); err != ErrDestroyed {
+		t.Fatalf("expect ErrDestroyed but got %v", err)
+	}
+}
+
+func TestRingBuffer_DrainBytes(t *testing.T) {
+	rb := New(8)
+	rb.Write([]byte("abcdef"))
+	rb.Read(make([]byte, 4))
+	rb.Write([]byte("gh\nij")) // 数据跨越终点
+
+	var line []byte
+	n := rb.DrainBytes(func(b byte) bool {
+		if b == '\n' {
+			return false
+		}
+		line = append(line, b)
+		return true
+	})
+	if n != 5 || string(line) != "efgh" {
+		t.Fatalf("expect 5 bytes consumed and efgh but got %d %q", n, line)
+	}
+	if string(rb.Bytes()) != "ij" {
+		t.Fatalf("expect ij to stay buffered but got %q", rb.Bytes())
+	}
+	if n = rb.DrainBytes(func(byte) bool { return true }); n != 2 || !rb.IsEmpty() {
+		t.Fatalf("expect the buffer to be drained but got %d", n)
+	}
+}
+
+func TestRingBuffer_UserData(t *testing.T) {
+	rb := New(8)
+	if rb.UserData() != nil {
+		t.Fatalf("expect no user data")
+	}
+	rb.SetUserData("conn-1")
+	if v, ok := rb.UserData().(string); !ok || v != "conn-1" {
+		t.Fatalf("expect conn-1 but got %v", rb.UserData())
+	}
+}
+
+func TestNewInState(t *testing.T) {
+	rb := NewInState([]byte("cd____ab"), 6, 2, false)
+	if rb.Length() != 4 || string(rb.Bytes()) != "abcd" {
+		t.Fatalf("expect abcd but got %q", rb.Bytes())
+	}
+	if rb.Free() != 4 {
+		t.Fatalf("expect 4 free bytes but got %d", rb.Free())
+	}
+
+	rb = NewInState([]byte("efghabcd"), 4, 4, true)
+	if !rb.IsFull() || string(rb.Bytes()) != "abcdefgh" {
+		t.Fatalf("expect a full buffer holding abcdefgh but got %v %q", rb.IsFull(), rb.Bytes())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expect a panic for out of range positions")
+		}
+	}()
+	NewInState(make([]byte, 4), 4, 0, false)
+}
+
+func TestRingBuffer_WriteStringRune(t *testing.T) {
+	rb := New(8)
+	if err := rb.WriteStringFull("abcdefghi"); err != ErrTooManyDataToWrite {
+		t.Fatalf("expect ErrTooManyDataToWrite but got %v", err)
+	}
+	rb.WriteString("abc")
+	if err := rb.WriteStringFull("123456"); err != ErrIsFull || rb.Length() != 3 {
+		t.Fatalf("expect ErrIsFull and nothing written but got %v %d", err, rb.Length())
+	}
+	if err := rb.WriteStringFull("12"); err != nil {
+		t.Fatalf("WriteStringFull failed: %v", err)
+	}
+
+	// 还剩 3 byte，"世" 占 3 byte，"界" 放不下
+	n, err := rb.WriteStringTruncateRune("世界")
+	if n != 3 || err != ErrTooManyDataToWrite || string(rb.Bytes()) != "abc12世" {
+		t.Fatalf("expect 世 to be written but got %d %v %q", n, err, rb.Bytes())
+	}
+
+	rb.Reset()
+	rb.WriteString("abcdef")
+	if n, err = rb.WriteStringTruncateRune("界"); n != 0 || err != ErrIsFull {
+		t.Fatalf("expect ErrIsFull but got %d %v", n, err)
+	}
+
+	// 配额只够 "h" 和半个 "é"
+	rb = New(8)
+	rb.SetWriteQuota(2)
+	if n, err = rb.WriteStringTruncateRune("héllo"); n != 1 || err != ErrQuotaExceeded || string(rb.Bytes()) != "h" {
+		t.Fatalf("expect only h within the quota but got %d %v %q", n, err, rb.Bytes())
+	}
+}
+
+func TestRingBuffer_IsWrapped(t *testing.T) {
+	tests := []struct {
+		rb      *RingBuffer
+		wrapped bool
+	}{
+		{NewInState(make([]byte, 8), 2, 6, false), false},
+		{NewInState(make([]byte, 8), 6, 2, false), true},
+		{NewInState(make([]byte, 8), 6, 0, false), false},
+		{NewInState(make([]byte, 8), 0, 0, true), false},
+		{NewInState(make([]byte, 8), 3, 3, true), true},
+		{NewInState(make([]byte, 8), 3, 3, false), false},
+	}
+	for i, tt := range tests {
+		if got := tt.rb.IsWrapped(); got != tt.wrapped {
+			t.Fatalf("#%d: expect IsWrapped %v but got %v", i, tt.wrapped, got)
+		}
+	}
+
+	rb := NewInState(make([]byte, 8), 6, 2, false)
+	rb.ResetRead()
+	if rb.IsWrapped() {
+		t.Fatalf("expect ResetRead to unwrap the data")
+	}
+}
+
+func TestRingBuffer_Read8(t *testing.T) {
+	rb := NewInState([]byte("efghij__abcd"), 8, 6, false)
+	a, err := rb.Read8()
+	if err != nil || string(a[:]) != "abcdefgh" {
+		t.Fatalf("expect abcdefgh but got %q %v", a, err)
+	}
+	if _, err = rb.Read4(); err != ErrIsEmpty || rb.Length() != 2 {
+		t.Fatalf("expect ErrIsEmpty and nothing consumed but got %v %d", err, rb.Length())
+	}
+	b, err := rb.Read2()
+	if err != nil || string(b[:]) != "ij" {
+		t.Fatalf("expect ij but got %q %v", b, err)
+	}
+}
+
+func TestRingBuffer_OpLog(t *testing.T) {
+	rb := New(8)
+	rb.Write([]byte("abc"))
+	if ops := rb.OpLog(); len(ops) != 0 {
+		t.Fatalf("expect no op recorded while disabled but got %v", ops)
+	}
+
+	rb.EnableOpLog(3)
+	rb.Write([]byte("defg"))
+	rb.Read(make([]byte, 2))
+	rb.Reset()
+	rb.WriteByte('x')
+
+	expected := []Op{
+		{Kind: OpRead, N: 2, R: 2, W: 7},
+		{Kind: OpReset, N: 5, R: 0, W: 0},
+		{Kind: OpWrite, N: 1, R: 0, W: 1},
+	}
+	ops := rb.OpLog()
+	if len(ops) != len(expected) {
+		t.Fatalf("expect %d ops but got %v", len(expected), ops)
+	}
+	for i := range ops {
+		if ops[i] != expected[i] {
+			t.Fatalf("#%d: expect %+v but got %+v", i, expected[i], ops[i])
+		}
+	}
+	if OpEvict.String() != "evict" {
+		t.Fatalf("expect evict but got %s", OpEvict)
+	}
+}
+
+func TestRingBuffer_WriteToN(t *testing.T) {
+	rb := NewInState([]byte("efgh__abcd"), 6, 4, false)
+	var out bytes.Buffer
+	n, err := rb.WriteToN(&out, 6)
+	if err != nil || n != 6 || out.String() != "abcdef" {
+		t.Fatalf("expect 6 bytes abcdef but got %d %q %v", n, out.String(), err)
+	}
+	if n, err = rb.WriteToN(&out, 10); err != nil || n != 2 || out.String() != "abcdefgh" {
+		t.Fatalf("expect the last 2 bytes but got %d %q %v", n, out.String(), err)
+	}
+	if n, err = rb.WriteToN(&out, 10); err != nil || n != 0 {
+		t.Fatalf("expect nothing to write but got %d %v", n, err)
+	}
+}
+
+func TestRingBuffer_SetBlocking(t *testing.T) {
+	rb := New(4)
+	rb.SetBlocking(true)
+
+	done := make(chan error, 1)
+	var written int
+	go func() {
+		var err error
+		written, err = rb.Write([]byte("abcdef"))
+		done <- err
+	}()
+	p := make([]byte, 6)
+	n, err := io.ReadFull(rb, p)
+	if err != nil || n != 6 || string(p) != "abcdef" {
+		t.Fatalf("expect abcdef but got %q %v", p[:n], err)
+	}
+	if err = <-done; err != nil || written != 6 {
+		t.Fatalf("expect a blocking Write of 6 bytes but got %d %v", written, err)
+	}
+
+	readDone := make(chan error, 1)
+	go func() {
+		_, err := rb.Read(make([]byte, 1))
+		readDone <- err
+	}()
+	select {
+	case err = <-readDone:
+		t.Fatalf("expect Read to block on an empty buffer but got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	rb.SetBlocking(false)
+	if err = <-readDone; err != ErrIsEmpty {
+		t.Fatalf("expect ErrIsEmpty once non-blocking but got %v", err)
+	}
+	if _, err = rb.Write([]byte("abcdef")); err != ErrTooManyDataToWrite {
+		t.Fatalf("expect ErrTooManyDataToWrite but got %v", err)
+	}
+}
+
+func TestRingBuffer_ReadLineAppend(t *testing.T) {
+	rb := NewInState([]byte("c\nde____ab"), 8, 4, false)
+	line, found, err := rb.ReadLineAppend(nil, '\n')
+	if err != nil || !found || string(line) != "abc\n" {
+		t.Fatalf("expect abc\\n but got %q %v %v", line, found, err)
+	}
+
+	line, found, err = rb.ReadLineAppend(line[:0], '\n')
+	if err != nil || found || string(line) != "de" {
+		t.Fatalf("expect a partial line de but got %q %v %v", line, found, err)
+	}
+	rb.Write([]byte("f\n"))
+	if line, found, _ = rb.ReadLineAppend(line, '\n'); !found || string(line) != "def\n" {
+		t.Fatalf("expect def\\n but got %q %v", line, found)
+	}
+	if _, _, err = rb.ReadLineAppend(nil, '\n'); err != ErrIsEmpty {
+		t.Fatalf("expect ErrIsEmpty but got %v", err)
+	}
+}
+
+func TestRingBuffer_SaveState(t *testing.T) {
+	rb := NewInState([]byte("cd____ab"), 6, 2, false)
+	s := rb.SaveState()
+
+	rb.Read(make([]byte, 3))
+	rb.Write([]byte("xyz"))
+	if err := rb.RestoreState(s); err != nil || string(rb.Bytes()) != "abcd" || rb.Free() != 4 {
+		t.Fatalf("expect abcd with 4 free bytes but got %q %d %v", rb.Bytes(), rb.Free(), err)
+	}
+
+	other := New(16)
+	if err := other.RestoreState(s); err != nil || other.Capacity() != 8 || string(other.Bytes()) != "abcd" {
+		t.Fatalf("expect a buffer of 8 bytes holding abcd but got %d %q %v", other.Capacity(), other.Bytes(), err)
+	}
+
+	// 外部分配的内存不能被替换
+	other = NewWithAllocator(16, func(n int) []byte { return make([]byte, n) })
+	other.SetFreeHook(func([]byte) error { return nil })
+	if err := other.RestoreState(s); err != ErrNotSupported || other.Capacity() != 16 {
+		t.Fatalf("expect ErrNotSupported and the buffer kept but got %v %d", err, other.Capacity())
+	}
+	other.Destroy()
+	if err := other.RestoreState(s); err != ErrDestroyed {
+		t.Fatalf("expect ErrDestroyed but got %v", err)
+	}
+}
+
+func TestRingBuffer_Grow(t *testing.T) {
+	rb := NewInState([]byte("efghabcd"), 4, 4, true)
+	if err := rb.Grow(4); err != nil || rb.Capacity() != 8 {
+		t.Fatalf("expect a smaller size to be a no-op but got %v %d", err, rb.Capacity())
+	}
+	if err := rb.Grow(12); err != nil {
+		t.Fatalf("Grow failed: %v", err)
+	}
+	if rb.Capacity() != 12 || rb.Free() != 4 || string(rb.Bytes()) != "abcdefgh" {
+		t.Fatalf("expect abcdefgh with 4 free bytes but got %d %d %q", rb.Capacity(), rb.Free(), rb.Bytes())
+	}
+
+	// 并发读写时 Grow 不能丢数据
+	rb = New(16)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			rb.WriteBlocking([]byte{byte(i)})
+		}
+		rb.Close()
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for size := 32; size <= 4096; size *= 2 {
+			rb.Grow(size)
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		b, err := rb.ReadByteContext(context.Background())
+		if err != nil || b != byte(i) {
+			t.Fatalf("#%d: expect %d but got %d %v", i, byte(i), b, err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestRingBuffer_ReadCloser(t *testing.T) {
+	rb := New(4)
+	rc := rb.ReadCloser()
+	go func() {
+		rb.WriteBlocking([]byte("hello world"))
+		rb.Close()
+	}()
+
+	data, err := io.ReadAll(rc)
+	if err != nil || string(data) != "hello world" {
+		t.Fatalf("expect hello world but got %q %v", data, err)
+	}
+	if err = rc.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func TestRingBuffer_ReadFrameCRC(t *testing.T) {
+	rb := New(20)
+	rb.Write(make([]byte, 15))
+	rb.Read(make([]byte, 15))
+
+	// 长度、payload、crc 三段都跨越终点
+	if err := rb.WriteFrameCRC([]byte("hello")); err != nil {
+		t.Fatalf("WriteFrameCRC failed: %v", err)
+	}
+	if err := rb.WriteFrameCRC([]byte("world")); err != ErrIsFull {
+		t.Fatalf("expect ErrIsFull but got %v", err)
+	}
+	p, err := rb.ReadFrameCRC()
+	if err != nil || string(p) != "hello" {
+		t.Fatalf("expect hello but got %q %v", p, err)
+	}
+
+	rb.WriteFrameCRC([]byte("world"))
+	data := rb.Bytes()
+	data[6] ^= 0xff
+	rb.Reset()
+	rb.Write(data)
+	if _, err = rb.ReadFrameCRC(); err != ErrCRCMismatch {
+		t.Fatalf("expect ErrCRCMismatch but got %v", err)
+	}
+	if !rb.IsEmpty() {
+		t.Fatalf("expect the corrupt frame to be consumed")
+	}
+}
+
+func TestRingBuffer_HasOffset(t *testing.T) {
+	rb := New(4)
+	if rb.HasOffset(0) {
+		t.Fatalf("expect offset 0 not to be buffered yet")
+	}
+	rb.Write([]byte("abcd"))
+	rb.Read(make([]byte, 2))
+	for seq, expected := range []bool{false, false, true, true, false} {
+		if got := rb.HasOffset(uint64(seq)); got != expected {
+			t.Fatalf("offset %d: expect %v but got %v", seq, expected, got)
+		}
+	}
+
+	rb.SetOverwrite(true)
+	rb.Write([]byte("efg"))
+	if rb.HasOffset(2) || !rb.HasOffset(3) || !rb.HasOffset(6) {
+		t.Fatalf("expect evicted offsets to be gone and new ones buffered")
+	}
+}
+
+func TestRingBuffer_SeekToOffset(t *testing.T) {
+	rb := New(8)
+	rb.Write([]byte("abcdef"))
+	rb.Read(make([]byte, 2))
+
+	if err := rb.SeekToOffset(1); err != ErrOffsetNotBuffered {
+		t.Fatalf("expect ErrOffsetNotBuffered for a consumed offset but got %v", err)
+	}
+	if err := rb.SeekToOffset(7); err != ErrOffsetNotBuffered {
+		t.Fatalf("expect ErrOffsetNotBuffered for a future offset but got %v", err)
+	}
+	if err := rb.SeekToOffset(4); err != nil {
+		t.Fatalf("SeekToOffset failed: %v", err)
+	}
+	if rb.ReadOffset() != 4 || string(rb.Bytes()) != "ef" {
+		t.Fatalf("expect to read ef from offset 4 but got %d %q", rb.ReadOffset(), rb.Bytes())
+	}
+	if err := rb.SeekToOffset(6); err != nil || !rb.IsEmpty() {
+		t.Fatalf("expect seeking to the write offset to drain the buffer but got %v", err)
+	}
+}
+
+func TestRingBuffer_WriteFrom(t *testing.T) {
+	rb := New(8)
+	src := bytes.NewBufferString("hello")
+	n, err := rb.WriteFrom(src)
+	if err != nil || n != 5 || string(rb.Bytes()) != "hello" {
+		t.Fatalf("expect hello but got %d %q %v", n, rb.Bytes(), err)
+	}
+
+	src = bytes.NewBufferString("world")
+	n, err = rb.WriteFrom(src)
+	if err != ErrTooManyDataToWrite || n != 3 || src.String() != "ld" {
+		t.Fatalf("expect 3 bytes written and ld left but got %d %q %v", n, src.String(), err)
+	}
+}
+
+func TestRingBuffer_SetWriteQuota(t *testing.T) {
+	rb := New(16)
+	rb.Write([]byte("abc"))
+	rb.SetWriteQuota(8)
+
+	n, err := rb.Write([]byte("defghi"))
+	if n != 5 || err != ErrQuotaExceeded {
+		t.Fatalf("expect 5 bytes written and ErrQuotaExceeded but got %d %v", n, err)
+	}
+	rb.Read(make([]byte, 8))
+	if _, err = rb.Write([]byte("x")); err != ErrQuotaExceeded {
+		t.Fatalf("expect ErrQuotaExceeded but got %v", err)
+	}
+	if err = rb.WriteByte('x'); err != ErrQuotaExceeded {
+		t.Fatalf("expect ErrQuotaExceeded but got %v", err)
+	}
+
+	rb.SetWriteQuota(0)
+	if err = rb.WriteByte('x'); err != nil {
+		t.Fatalf("expect no quota but got %v", err)
+	}
+}
+
+func TestRingBuffer_ReadByteBatch(t *testing.T) {
+	rb := NewInState([]byte("cd____ab"), 6, 2, false)
+	if p, err := rb.ReadByteBatch(-1); err != nil || string(p) != "abcd" || rb.Length() != 0 {
+		t.Fatalf("expect a negative max to read everything but got %q %v", p, err)
+	}
+	rb = NewInState([]byte("cd____ab"), 6, 2, false)
+	p, err := rb.ReadByteBatch(3)
+	if err != nil || string(p) != "abc" {
+		t.Fatalf("expect abc but got %q %v", p, err)
+	}
+	if p, err = rb.ReadByteBatch(3); err != nil || string(p) != "d" {
+		t.Fatalf("expect d but got %q %v", p, err)
+	}
+	if _, err = rb.ReadByteBatch(3); err != ErrIsEmpty {
+		t.Fatalf("expect ErrIsEmpty but got %v", err)
+	}
+}
+
+func TestRingBuffer_WriteTimeoutRemainder(t *testing.T) {
+	rb := New(4)
+	n, rest, err := rb.WriteTimeoutRemainder([]byte("abcdef"), 20*time.Millisecond)
+	if n != 4 || string(rest) != "ef" || err != context.DeadlineExceeded {
+		t.Fatalf("expect 4 bytes written and ef left but got %d %q %v", n, rest, err)
+	}
+
+	rb.Reset()
+	n, rest, err = rb.WriteTimeoutRemainder([]byte("ab"), 20*time.Millisecond)
+	if n != 2 || rest != nil || err != nil {
+		t.Fatalf("expect all written but got %d %q %v", n, rest, err)
+	}
+}
+
+func TestRingBuffer_WriteIfBelow(t *testing.T) {
+	rb := New(10)
+	if n, err := rb.WriteIfBelow([]byte("abcde"), 0.5); n != 5 || err != nil {
+		t.Fatalf("expect 5 bytes written but got %d %v", n, err)
+	}
+	if n, err := rb.WriteIfBelow([]byte("f"), 0.5); n != 0 || err != ErrFillTooHigh {
+		t.Fatalf("expect ErrFillTooHigh at half full but got %d %v", n, err)
+	}
+	if n, err := rb.WriteIfBelow([]byte("f"), 0.8); n != 1 || err != nil {
+		t.Fatalf("expect 1 byte written but got %d %v", n, err)
+	}
+	if rb.Length() != 6 {
+		t.Fatalf("expect length 6 but got %d", rb.Length())
+	}
+}
+
+func TestRingBuffer_ReadFullAfterClose(t *testing.T) {
+	rb := New(8)
+	rb.SetBlocking(true)
+	go func() {
+		rb.Write([]byte("abc"))
+		time.Sleep(20 * time.Millisecond)
+		rb.Close()
+	}()
+
+	p := make([]byte, 5)
+	n, err := io.ReadFull(rb, p)
+	if n != 3 || err != io.ErrUnexpectedEOF {
+		t.Fatalf("expect 3 bytes and io.ErrUnexpectedEOF but got %d %v", n, err)
+	}
+	if n, err = io.ReadFull(rb, p); n != 0 || err != io.EOF {
+		t.Fatalf("expect io.EOF but got %d %v", n, err)
+	}
+}
+
+func TestRingBuffer_WriteFront(t *testing.T) {
+	rb := New(8)
+	rb.Write([]byte("abcd"))
+	rb.Read(make([]byte, 1))
+
+	// r 从 1 往回挪 3，绕过起点
+	if n, err := rb.WriteFront([]byte("xyz")); n != 3 || err != nil {
+		t.Fatalf("expect 3 bytes written but got %d %v", n, err)
+	}
+	if string(rb.Bytes()) != "xyzbcd" {
+		t.Fatalf("expect xyzbcd but got %q", rb.Bytes())
+	}
+	if _, err := rb.WriteFront([]byte("123")); err != ErrTooManyDataToWrite {
+		t.Fatalf("expect ErrTooManyDataToWrite but got %v", err)
+	}
+	rb.WriteFront([]byte("12"))
+	if !rb.IsFull() || string(rb.Bytes()) != "12xyzbcd" {
+		t.Fatalf("expect a full buffer holding 12xyzbcd but got %v %q", rb.IsFull(), rb.Bytes())
+	}
+
+	rb = New(8)
+	rb.SetWriteQuota(4)
+	rb.Write([]byte("ab"))
+	if _, err := rb.WriteFront([]byte("CTL")); err != ErrQuotaExceeded {
+		t.Fatalf("expect ErrQuotaExceeded but got %v", err)
+	}
+	if n, err := rb.WriteFront([]byte("C")); n != 1 || err != nil {
+		t.Fatalf("expect a priority write within the quota but got %d %v", n, err)
+	}
+}
+
+func TestRingBuffer_NotifyNonEmpty(t *testing.T) {
+	rb := New(8)
+	ch := rb.NotifyNonEmpty()
+	select {
+	case <-ch:
+		t.Fatalf("expect no notification on an empty buffer")
+	default:
+	}
+
+	rb.WriteByte('a')
+	select {
+	case <-ch:
+	default:
+		t.Fatalf("expect a notification after a write")
+	}
+	select {
+	case <-rb.NotifyNonEmpty():
+	default:
+		t.Fatalf("expect an immediate notification on a non-empty buffer")
+	}
+
+	rb.ReadByte()
+	ch = rb.NotifyNonEmpty()
+	go rb.Write([]byte("b"))
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatalf("expect the re-armed channel to be closed")
+	}
+}
+
+func TestRingBuffer_Barrier(t *testing.T) {
+	rb := New(8)
+	rb.Write([]byte("abcd"))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- rb.Barrier(context.Background())
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	if _, err := rb.Write([]byte("e")); err != ErrPaused {
+		t.Fatalf("expect writes to be held back by the barrier but got %v", err)
+	}
+	rb.Read(make([]byte, 2))
+	select {
+	case err := <-done:
+		t.Fatalf("expect the barrier to wait for all the data but it returned %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	rb.Read(make([]byte, 2))
+	if err := <-done; err != nil {
+		t.Fatalf("Barrier failed: %v", err)
+	}
+	if _, err := rb.Write([]byte("e")); err != nil {
+		t.Fatalf("expect writes to resume after the barrier but got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := rb.Barrier(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expect context.DeadlineExceeded but got %v", err)
+	}
+}
+
+func TestRingBuffer_FullnessSampler(t *testing.T) {
+	rb := New(10)
+	if rb.FullnessDistribution() != nil {
+		t.Fatalf("expect no distribution before sampling")
+	}
+	rb.Write([]byte("abcde"))
+	rb.EnableFullnessSampler(time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	rb.Close()
+
+	dist := rb.FullnessDistribution()
+	if len(dist) != 10 || dist[5] != 1 {
+		t.Fatalf("expect all samples in the 50%% decile but got %v", dist)
+	}
+	rb.mu.Lock()
+	stopped := rb.samplerStop == nil
+	rb.mu.Unlock()
+	if !stopped {
+		t.Fatalf("expect Close to stop the sampler")
+	}
+}
+
+func TestSPSCRingBuffer(t *testing.T) {
+	rb := NewSPSC(6)
+	if rb.Capacity() != 8 {
+		t.Fatalf("expect capacity 8 but got %d", rb.Capacity())
+	}
+	if n, err := rb.Write([]byte("abcdefghij")); n != 8 || err != ErrTooManyDataToWrite {
+		t.Fatalf("expect 8 bytes written but got %d %v", n, err)
+	}
+	if err := rb.WriteByte('x'); err != ErrIsFull {
+		t.Fatalf("expect ErrIsFull but got %v", err)
+	}
+	p := make([]byte, 5)
+	rb.Read(p)
+	rb.Write([]byte("ijk")) // 跨越终点
+	p = make([]byte, 8)
+	if n, _ := rb.Read(p); string(p[:n]) != "fghijk" {
+		t.Fatalf("expect fghijk but got %q", p[:n])
+	}
+	if _, err := rb.ReadByte(); err != ErrIsEmpty {
+		t.Fatalf("expect ErrIsEmpty but got %v", err)
+	}
+
+	// 一个 producer 一个 consumer
+	const count = 10000
+	rb = NewSPSC(64)
+	go func() {
+		for i := 0; i < count; {
+			if rb.WriteByte(byte(i)) != nil {
+				runtime.Gosched()
+				continue
+			}
+			i++
+		}
+	}()
+	for i := 0; i < count; {
+		b, err := rb.ReadByte()
+		if err != nil {
+			runtime.Gosched()
+			continue
+		}
+		if b != byte(i) {
+			t.Fatalf("#%d: expect %d but got %d", i, byte(i), b)
+		}
+		i++
+	}
+}
+
+func TestRingBuffer_LazyCond(t *testing.T) {
+	rb := New(8)
+	rb.Write([]byte("ab"))
+	rb.ReadBlocking(make([]byte, 2))
+	if rb.cond != nil {
+		t.Fatalf("expect no cond as long as nobody waits")
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		rb.Write([]byte("c"))
+	}()
+	if b, err := rb.ReadByteContext(context.Background()); err != nil || b != 'c' {
+		t.Fatalf("expect c but got %q %v", b, err)
+	}
+	if rb.cond == nil {
+		t.Fatalf("expect the cond to be created by the first wait")
+	}
+}
+
+func TestRingBuffer_ReadLine(t *testing.T) {
+	rb := New(32)
+	rb.Write([]byte("GET / HTTP/1.1\r\nHost: a\n\nx\ry"))
+
+	for _, expected := range []string{"GET / HTTP/1.1", "Host: a", ""} {
+		line, err := rb.ReadLine()
+		if err != nil || string(line) != expected {
+			t.Fatalf("expect %q but got %q %v", expected, line, err)
+		}
+	}
+	if _, err := rb.ReadLine(); err != ErrIsEmpty || rb.Length() != 3 {
+		t.Fatalf("expect ErrIsEmpty and the partial line kept but got %v %d", err, rb.Length())
+	}
+	rb.Write([]byte("\r\n"))
+	if line, err := rb.ReadLine(); err != nil || string(line) != "x\ry" {
+		t.Fatalf("expect x\\ry but got %q %v", line, err)
+	}
+}
+
+func TestRingBuffer_ShrinkToWithOverflow(t *testing.T) {
+	rb := NewInState([]byte("efgh____abcd"), 8, 4, false)
+	overflow, err := rb.ShrinkToWithOverflow(5)
+	if err != nil || string(overflow) != "abc" {
+		t.Fatalf("expect the oldest bytes abc as overflow but got %q %v", overflow, err)
+	}
+	if rb.Capacity() != 5 || !rb.IsFull() || string(rb.Bytes()) != "defgh" {
+		t.Fatalf("expect a full buffer of 5 bytes holding defgh but got %d %q", rb.Capacity(), rb.Bytes())
+	}
+	if rb.ReadOffset() != 3 {
+		t.Fatalf("expect the overflow to be consumed but got read offset %d", rb.ReadOffset())
+	}
+
+	if overflow, err = rb.ShrinkToWithOverflow(8); overflow != nil || err != nil || rb.Capacity() != 5 {
+		t.Fatalf("expect a larger size to be a no-op but got %q %v %d", overflow, err, rb.Capacity())
+	}
+}
+
+func TestRingBuffer_SetFair(t *testing.T) {
+	rb := New(4)
+	rb.SetFair(true)
+	rb.Write([]byte("0000"))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		rb.WriteBlocking([]byte("AAAAAAAA"))
+	}()
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rb.WriteBlocking([]byte("b"))
+		}()
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	var out []byte
+	for len(out) < 16 {
+		b, err := rb.ReadByteContext(context.Background())
+		if err != nil {
+			t.Fatalf("ReadByteContext failed: %v", err)
+		}
+		out = append(out, b)
+	}
+	wg.Wait()
+	// 大的 writer 先到，small writer 不能插队
+	if string(out) != "0000AAAAAAAAbbbb" {
+		t.Fatalf("expect the writers to be served in arrival order but got %q", out)
+	}
+
+	// 放弃排队的 writer 不会卡住后面的人
+	rb.Write([]byte("0000"))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	go rb.WriteContext(context.Background(), []byte("x"))
+	time.Sleep(5 * time.Millisecond)
+	if _, err := rb.WriteContext(ctx, []byte("y")); err != context.DeadlineExceeded {
+		t.Fatalf("expect context.DeadlineExceeded but got %v", err)
+	}
+	rb.Read(make([]byte, 4))
+	done := make(chan error, 1)
+	go func() {
+		_, err := rb.WriteBlocking([]byte("z"))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("WriteBlocking failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expect a writer queued after a given up ticket to be served")
+	}
+}
+
+func TestRingBuffer_WriteByteBuffered(t *testing.T) {
+	rb := New(100)
+	for i := 0; i < stageSize-1; i++ {
+		rb.WriteByteBuffered(byte(i))
+	}
+	if !rb.IsEmpty() {
+		t.Fatalf("expect the staged bytes not to be readable before a flush")
+	}
+	rb.WriteByteBuffered(stageSize - 1)
+	if rb.Length() != stageSize {
+		t.Fatalf("expect %d bytes flushed but got %d", stageSize, rb.Length())
+	}
+
+	for i := 0; i < 40; i++ {
+		rb.WriteByteBuffered('x')
+	}
+	if err := rb.FlushBytes(); err != ErrTooManyDataToWrite || rb.Length() != 100 {
+		t.Fatalf("expect a partial flush but got %v %d", err, rb.Length())
+	}
+	for i := 0; i < stageSize-4; i++ {
+		if err := rb.WriteByteBuffered('y'); err != nil {
+			t.Fatalf("#%d: expect the byte to be staged but got %v", i, err)
+		}
+	}
+	if err := rb.WriteByteBuffered('z'); err != ErrIsFull {
+		t.Fatalf("expect ErrIsFull once the staging array is full too but got %v", err)
+	}
+
+	rb.Read(make([]byte, 100))
+	if err := rb.FlushBytes(); err != nil || rb.Length() != stageSize {
+		t.Fatalf("expect the staged bytes to be flushed but got %v %d", err, rb.Length())
+	}
+}
+
+func TestRingBuffer_WouldFit(t *testing.T) {
+	rb := New(8)
+	rb.Write([]byte("abcde"))
+	if !rb.WouldFit(3) || rb.WouldFit(4) {
+		t.Fatalf("expect exactly 3 more bytes to fit")
+	}
+	if err := rb.WriteFull([]byte("fghi")); err != ErrIsFull || rb.Length() != 5 {
+		t.Fatalf("expect ErrIsFull and nothing written but got %v %d", err, rb.Length())
+	}
+	if err := rb.WriteFull([]byte("fgh")); err != nil || !rb.IsFull() {
+		t.Fatalf("expect the buffer to be filled but got %v", err)
+	}
+
+	// 配额不够时一个 byte 也不写
+	rb = New(8)
+	rb.SetWriteQuota(3)
+	if err := rb.WriteStringFull("abcd"); err != ErrQuotaExceeded || rb.Length() != 0 {
+		t.Fatalf("expect ErrQuotaExceeded and nothing written but got %v %d", err, rb.Length())
+	}
+	rb.Destroy()
+	if err := rb.WriteFull([]byte("a")); err != ErrDestroyed {
+		t.Fatalf("expect ErrDestroyed but got %v", err)
+	}
+}
+
+func TestRingBuffer_ReadLineIdleTimeout(t *testing.T) {
+	rb := New(32)
+	go func() {
+		for _, c := range []byte("hello\nwor") {
+			time.Sleep(10 * time.Millisecond)
+			rb.WriteByte(c)
+		}
+	}()
+
+	// 总耗时超过 gap，但每个 byte 之间的间隔都小于 gap
+	line, err := rb.ReadLineIdleTimeout(50 * time.Millisecond)
+	if err != nil || string(line) != "hello" {
+		t.Fatalf("expect hello but got %q %v", line, err)
+	}
+	if _, err = rb.ReadLineIdleTimeout(50 * time.Millisecond); err != context.DeadlineExceeded {
+		t.Fatalf("expect context.DeadlineExceeded on silence but got %v", err)
+	}
+	if string(rb.Bytes()) != "wor" {
+		t.Fatalf("expect the partial line to stay buffered but got %q", rb.Bytes())
+	}
+}
+
+func TestRingBuffer_Normalize(t *testing.T) {
+	rb := NewInState([]byte("___abc__"), 3, 6, false)
+	rb.Normalize()
+	if rb.r != 0 || rb.w != 3 || string(rb.Bytes()) != "abc" {
+		t.Fatalf("expect abc at offset 0 but got r=%d w=%d %q", rb.r, rb.w, rb.Bytes())
+	}
+
+	rb = NewInState([]byte("c_____ab"), 6, 1, false)
+	rb.Normalize()
+	if rb.r != 6 || string(rb.Bytes()) != "abc" {
+		t.Fatalf("expect wrapped data to be left as is but got r=%d %q", rb.r, rb.Bytes())
+	}
+
+	rb = NewInState(make([]byte, 8), 5, 5, false)
+	rb.Normalize()
+	if rb.r != 0 || rb.w != 0 || !rb.IsEmpty() {
+		t.Fatalf("expect the positions of an empty buffer to be reset but got r=%d w=%d", rb.r, rb.w)
+	}
+}
+
+func TestRingBuffer_ReadLIFO(t *testing.T) {
+	rb := NewInState([]byte("cd____ab"), 6, 2, false)
+	p := make([]byte, 3)
+	n, err := rb.ReadLIFO(p)
+	if err != nil || n != 3 || string(p) != "dcb" {
+		t.Fatalf("expect dcb but got %q %v", p[:n], err)
+	}
+	if rb.w != 7 || string(rb.Bytes()) != "a" {
+		t.Fatalf("expect w to move back across the start but got w=%d %q", rb.w, rb.Bytes())
+	}
+
+	rb.Write([]byte("xy"))
+	if n, _ = rb.ReadLIFO(p); n != 3 || string(p) != "yxa" {
+		t.Fatalf("expect yxa but got %q", p[:n])
+	}
+	if _, err = rb.ReadLIFO(p); err != ErrIsEmpty {
+		t.Fatalf("expect ErrIsEmpty but got %v", err)
+	}
+
+	// 流计数不能回退: WriteSeq 单调递增，Barrier 能等到
+	rb = New(8)
+	_, seq1, _ := rb.WriteSeq([]byte("ab"))
+	rb.ReadLIFO(p)
+	_, seq2, _ := rb.WriteSeq([]byte("cd"))
+	if seq1 != 0 || seq2 != 2 {
+		t.Fatalf("expect monotonic offsets 0 and 2 but got %d %d", seq1, seq2)
+	}
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		done <- rb.Barrier(ctx)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	rb.ReadLIFO(p)
+	if err = <-done; err != nil {
+		t.Fatalf("expect Barrier to return once everything is consumed but got %v", err)
+	}
+}
+
+func TestRingBuffer_PeekLine(t *testing.T) {
+	rb := New(32)
+	rb.Write([]byte("GET / HT"))
+	if line, found := rb.PeekLine(); found || string(line) != "GET / HT" {
+		t.Fatalf("expect a partial line but got %q %v", line, found)
+	}
+	rb.Write([]byte("TP/1.1\r\nHost"))
+	if line, found := rb.PeekLine(); !found || string(line) != "GET / HTTP/1.1" {
+		t.Fatalf("expect the request line but got %q %v", line, found)
+	}
+	if rb.Length() != 20 {
+		t.Fatalf("expect nothing to be consumed but got length %d", rb.Length())
+	}
+}
+
+func TestRingBuffer_DistributeTo(t *testing.T) {
+	src := New(32)
+	src.Write([]byte("aaabbbcccddd"))
+	targets := []*RingBuffer{New(3), New(8), New(8)}
+
+	for i := 0; i < 3; i++ {
+		if n, err := src.DistributeTo(targets, 3); n != 3 || err != nil {
+			t.Fatalf("#%d: expect 3 bytes moved but got %d %v", i, n, err)
+		}
+	}
+	// 轮到第一个 target 时它已经满了，跳过
+	if n, err := src.DistributeTo(targets, 3); n != 3 || err != nil {
+		t.Fatalf("expect 3 bytes moved but got %d %v", n, err)
+	}
+	expected := []string{"aaa", "bbbddd", "ccc"}
+	for i, tb := range targets {
+		if string(tb.Bytes()) != expected[i] {
+			t.Fatalf("target #%d: expect %s but got %q", i, expected[i], tb.Bytes())
+		}
+	}
+	if _, err := src.DistributeTo(targets, 3); err != ErrIsEmpty {
+		t.Fatalf("expect ErrIsEmpty but got %v", err)
+	}
+
+	src.Write([]byte("x"))
+	full := []*RingBuffer{New(1), New(1)}
+	full[0].WriteByte('-')
+	full[1].WriteByte('-')
+	if _, err := src.DistributeTo(full, 3); err != ErrIsFull {
+		t.Fatalf("expect ErrIsFull but got %v", err)
+	}
+}
+
+func TestRingBuffer_Repair(t *testing.T) {
+	rb := New(8)
+	rb.Write([]byte("abc"))
+	if fixes := rb.Repair(); fixes != nil {
+		t.Fatalf("expect a consistent buffer to be left as is but got %v", fixes)
+	}
+
+	rb.r, rb.w, rb.isFull = 10, -1, true
+	fixes := rb.Repair()
+	if len(fixes) != 3 {
+		t.Fatalf("expect 3 fixes but got %v", fixes)
+	}
+	if rb.r != 2 || rb.w != 0 || rb.IsFull() || rb.Length() != 6 {
+		t.Fatalf("expect r=2 w=0 and 6 bytes but got r=%d w=%d full=%v length=%d", rb.r, rb.w, rb.IsFull(), rb.Length())
+	}
+}
+
+func TestRingBuffer_ReadLines(t *testing.T) {
+	rb := NewInState([]byte("c\r\nd\n\npa_____a\nb"), 13, 8, false)
+	lines, err := rb.ReadLines(10)
+	if err != nil || len(lines) != 4 {
+		t.Fatalf("expect 4 lines but got %q %v", lines, err)
+	}
+	for i, expected := range []string{"a", "bc", "d", ""} {
+		if string(lines[i]) != expected {
+			t.Fatalf("line #%d: expect %q but got %q", i, expected, lines[i])
+		}
+	}
+	if string(rb.Bytes()) != "pa" {
+		t.Fatalf("expect the partial line to stay buffered but got %q", rb.Bytes())
+	}
+	if _, err = rb.ReadLines(10); err != ErrIsEmpty {
+		t.Fatalf("expect ErrIsEmpty but got %v", err)
+	}
+
+	rb.Write([]byte("\nx\ny\n"))
+	if lines, _ = rb.ReadLines(2); len(lines) != 2 || string(lines[1]) != "x" {
+		t.Fatalf("expect at most 2 lines but got %q", lines)
+	}
+	rb.Write([]byte("z\n"))
+	if lines, _ = rb.ReadLines(0); len(lines) != 2 || string(lines[0]) != "y" || string(lines[1]) != "z" {
+		t.Fatalf("expect max 0 to read every line but got %q", lines)
+	}
+}
+
+func TestRingBuffer_WriteByteOverwrite(t *testing.T) {
+	rb := New(10)
+	rb.SetOverwrite(true)
+	for i := 0; i < 1000; i++ {
+		if err := rb.WriteByte(byte('a' + i%26)); err != nil {
+			t.Fatalf("WriteByte #%d failed: %v", i, err)
+		}
+		if expected := i + 1; expected < 10 && rb.Length() != expected {
+			t.Fatalf("expect length %d but got %d", expected, rb.Length())
+		}
+	}
+	if !rb.IsFull() || rb.Length() != 10 || rb.Free() != 0 {
+		t.Fatalf("expect full buffer but got length %d, free %d", rb.Length(), rb.Free())
+	}
+	if rb.EvictedBytes() != 990 {
+		t.Fatalf("expect 990 evicted bytes but got %d", rb.EvictedBytes())
+	}
+	// 1000 = 38*26 + 12, 最后 10 个 byte 是 'c'..'l'
+	if string(rb.Bytes()) != "cdefghijkl" {
+		t.Fatalf("expect the last 10 bytes but got %q", rb.Bytes())
+	}
+
+	rb.SetOverwrite(false)
+	if err := rb.WriteByte('x'); err != ErrIsFull {
+		t.Fatalf("expect ErrIsFull but got %v", err)
+	}
+}
+
+func TestRingBuffer_WriteHash(t *testing.T) {
+	rb := New(8)
+	if rb.WriteHashSum() != nil {
+		t.Fatalf("expect no digest without a hash")
+	}
+	rb.SetWriteHash(crc32.NewIEEE())
+
+	var all []byte
+	buf := make([]byte, 8)
+	for i := 0; i < 10; i++ {
+		p := []byte(strings.Repeat(string(rune('a'+i)), i%5+1))
+		n, _ := rb.Write(p)
+		all = append(all, p[:n]...)
+		if rb.WriteByte('|') == nil {
+			all = append(all, '|')
+		}
+		rb.Read(buf[:i%4+3])
+	}
+	rb.SetOverwrite(true)
+	rb.Write([]byte("0123456789"))
+	all = append(all, "0123456789"...)
+
+	want := crc32.ChecksumIEEE(all)
+	if got := binary.BigEndian.Uint32(rb.WriteHashSum()); got != want {
+		t.Fatalf("expect digest %x but got %x", want, got)
+	}
+}
+
+func TestRingBuffer_ReadHash(t *testing.T) {
+	rb := New(8)
+	rb.SetWriteHash(crc32.NewIEEE())
+	rb.SetReadHash(crc32.NewIEEE())
+
+	var read []byte
+	buf := make([]byte, 8)
+	for i := 0; i < 20; i++ {
+		rb.Write([]byte(strings.Repeat(string(rune('a'+i)), i%5+1)))
+		n, _ := rb.Read(buf[:i%4+3])
+		read = append(read, buf[:n]...)
+	}
+	for !rb.IsEmpty() {
+		c, _ := rb.ReadByte()
+		read = append(read, c)
+	}
+
+	if got, want := binary.BigEndian.Uint32(rb.ReadHashSum()), crc32.ChecksumIEEE(read); got != want {
+		t.Fatalf("expect digest %x but got %x", want, got)
+	}
+	if !bytes.Equal(rb.ReadHashSum(), rb.WriteHashSum()) {
+		t.Fatalf("expect the read digest to match the write digest once drained")
+	}
+}
+
+func TestRingBuffer_ReserveFrame(t *testing.T) {
+	rb := New(10)
+	rb.Write([]byte("ab"))
+	if ok, err := rb.ReserveFrame(11); ok || err != ErrTooManyDataToWrite {
+		t.Fatalf("expect ErrTooManyDataToWrite but got %v %v", ok, err)
+	}
+	if ok, err := rb.ReserveFrame(9); ok || err != nil {
+		t.Fatalf("expect not to fit but got %v %v", ok, err)
+	}
+	if ok, err := rb.ReserveFrame(6); !ok || err != nil {
+		t.Fatalf("expect to reserve but got %v %v", ok, err)
+	}
+	if _, err := rb.ReserveFrame(1); err != ErrFrameReserved {
+		t.Fatalf("expect ErrFrameReserved but got %v", err)
+	}
+	if rb.Free() != 2 {
+		t.Fatalf("expect 2 unreserved bytes but got %d", rb.Free())
+	}
+
+	// 其他 writer 只能用未预留的空间，也不会插到 frame 中间
+	rb.WriteReserved([]byte("HDR"))
+	if n, err := rb.Write([]byte("xyz")); n != 2 || err != ErrTooManyDataToWrite {
+		t.Fatalf("expect a short write of 2 bytes but got %d %v", n, err)
+	}
+	if n, err := rb.WriteReserved([]byte("body")); n != 3 || err != ErrTooManyDataToWrite {
+		t.Fatalf("expect 3 bytes to fit in the reservation but got %d %v", n, err)
+	}
+	if string(rb.Bytes()) != "abxy" {
+		t.Fatalf("expect the pending frame to be invisible but got %q", rb.Bytes())
+	}
+	if err := rb.CommitFrame(); err != nil {
+		t.Fatalf("CommitFrame failed: %v", err)
+	}
+	if string(rb.Bytes()) != "abxyHDRbod" || !rb.IsFull() {
+		t.Fatalf("expect the committed frame but got %q", rb.Bytes())
+	}
+	if err := rb.CommitFrame(); err != ErrNoFrameReserved {
+		t.Fatalf("expect ErrNoFrameReserved but got %v", err)
+	}
+
+	rb.Reset()
+	rb.ReserveFrame(8)
+	rb.WriteReserved([]byte("dropped"))
+	rb.AbortFrame()
+	if !rb.IsEmpty() || rb.Free() != 10 {
+		t.Fatalf("expect the reservation to be released but got free %d", rb.Free())
+	}
+}
+
+func TestRingBuffer_SetMinRead(t *testing.T) {
+	rb := New(16)
+	rb.SetMinRead(4)
+	rb.Write([]byte("ab"))
+	p := make([]byte, 8)
+	if _, err := rb.Read(p); err != ErrIsEmpty {
+		t.Fatalf("expect ErrIsEmpty below the watermark but got %v", err)
+	}
+	if n, err := rb.Read(p[:2]); err != nil || n != 2 {
+		t.Fatalf("expect the watermark to be capped at len(p) but got %d %v", n, err)
+	}
+
+	rb.SetBlocking(true)
+	done := make(chan int, 1)
+	go func() {
+		n, _ := rb.Read(p)
+		done <- n
+	}()
+	rb.Write([]byte("abc"))
+	select {
+	case n := <-done:
+		t.Fatalf("expect Read to wait for 4 bytes but got %d", n)
+	case <-time.After(20 * time.Millisecond):
+	}
+	rb.Write([]byte("de"))
+	if n := <-done; n != 5 || string(p[:n]) != "abcde" {
+		t.Fatalf("expect abcde but got %q", p[:n])
+	}
+
+	rb.Write([]byte("x"))
+	rb.Close()
+	if n, err := rb.Read(p); n != 1 || err != nil {
+		t.Fatalf("expect the remaining byte after Close but got %d %v", n, err)
+	}
+	if _, err := rb.Read(p); err != io.EOF {
+		t.Fatalf("expect io.EOF but got %v", err)
+	}
+}
+
+func TestRingBuffer_Chunks(t *testing.T) {
+	rb := New(10)
+	if rb.Chunks(4) != nil {
+		t.Fatalf("expect no chunks of an empty buffer")
+	}
+
+	rb = NewInState([]byte("fghi_abcde"), 5, 4, false)
+	rb.Write([]byte("j"))
+	chunks := rb.Chunks(4)
+	if len(chunks) != 3 {
+		t.Fatalf("expect 3 chunks but got %q", chunks)
+	}
+	for i, expected := range []string{"abcd", "efgh", "ij"} {
+		if string(chunks[i]) != expected {
+			t.Fatalf("chunk #%d: expect %q but got %q", i, expected, chunks[i])
+		}
+	}
+	chunks[0] = append(chunks[0], 'X')
+	if string(chunks[1]) != "efgh" {
+		t.Fatalf("expect appending to a chunk to leave the next one alone but got %q", chunks[1])
+	}
+	if rb.Length() != 10 {
+		t.Fatalf("expect Chunks not to consume but got length %d", rb.Length())
+	}
+}
+
+func TestRingBuffer_Offer(t *testing.T) {
+	rb := New(4)
+	if n, err := rb.Offer([]byte("abcdef"), 16, false); n != 6 || err != nil {
+		t.Fatalf("expect to grow and write 6 bytes but got %d %v", n, err)
+	}
+	if rb.Capacity() != 8 {
+		t.Fatalf("expect the size to double to 8 but got %d", rb.Capacity())
+	}
+	if n, err := rb.Offer([]byte("0123456789xyz"), 16, false); n != 10 || err != ErrTooManyDataToWrite {
+		t.Fatalf("expect a short write up to maxGrow but got %d %v", n, err)
+	}
+	if rb.Capacity() != 16 || string(rb.Bytes()) != "abcdef0123456789" {
+		t.Fatalf("expect a full buffer of 16 bytes but got %d %q", rb.Capacity(), rb.Bytes())
+	}
+	if n, err := rb.Offer([]byte("x"), 16, false); n != 0 || err != ErrIsFull {
+		t.Fatalf("expect ErrIsFull at maxGrow but got %d %v", n, err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := rb.Offer([]byte("xyz"), 16, true)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("expect Offer to block at maxGrow but got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	rb.Read(make([]byte, 6))
+	if err := <-done; err != nil {
+		t.Fatalf("expect the blocked Offer to finish but got %v", err)
+	}
+	if rb.Capacity() != 16 || string(rb.Bytes()) != "0123456789xyz" {
+		t.Fatalf("expect 0123456789xyz but got %q", rb.Bytes())
+	}
+
+	// soft limit 限制住的 buffer 不扩容
+	rb = New(8)
+	rb.SetSoftLimit(4)
+	if n, err := rb.Offer([]byte("abcdef"), 64, false); n != 4 || err != ErrTooManyDataToWrite {
+		t.Fatalf("expect a short write of 4 bytes but got %d %v", n, err)
+	}
+	if rb.Capacity() != 8 {
+		t.Fatalf("expect no growth under a soft limit but got %d", rb.Capacity())
+	}
+	if n, err := rb.Offer(nil, 64, false); n != 0 || err != nil || rb.Capacity() != 8 {
+		t.Fatalf("expect an empty offer to do nothing but got %d %v, capacity %d", n, err, rb.Capacity())
+	}
+}
+
+func TestRingBuffer_HugeSize(t *testing.T) {
+	// 不分配内存，只检查位置的计算在 size 接近 math.MaxInt 时不会溢出
+	size := math.MaxInt - 1
+	rb := &RingBuffer{size: size, r: size - 10, w: size - 5}
+	if rb.Length() != 5 || rb.Free() != size-5 {
+		t.Fatalf("expect length 5 but got %d, free %d", rb.Length(), rb.Free())
+	}
+
+	rb.advance(10)
+	if rb.w != 5 || rb.Length() != 15 || rb.Free() != size-15 {
+		t.Fatalf("expect w to wrap to 5 but got w %d, length %d, free %d", rb.w, rb.Length(), rb.Free())
+	}
+	rb.consume(12)
+	if rb.r != 2 || rb.Length() != 3 {
+		t.Fatalf("expect r to wrap to 2 but got r %d, length %d", rb.r, rb.Length())
+	}
+	rb.advance(size - 3)
+	if !rb.IsFull() || rb.Length() != size || rb.Free() != 0 {
+		t.Fatalf("expect a full buffer but got length %d, free %d", rb.Length(), rb.Free())
+	}
+	if rb.written != uint64(size)+7 || rb.readOff != 12 {
+		t.Fatalf("expect the stream counters not to wrap but got %d %d", rb.written, rb.readOff)
+	}
+}
+
+func TestRingBuffer_ReadAllFrames(t *testing.T) {
+	rb := New(32)
+	if _, err := rb.ReadAllFrames(2, true, 10); err != ErrIsEmpty {
+		t.Fatalf("expect ErrIsEmpty but got %v", err)
+	}
+	rb.Write(make([]byte, 20))
+	rb.Read(make([]byte, 20)) // 让 frame 跨越终点
+	for _, p := range []string{"abc", "", "defgh", "ij"} {
+		if err := rb.WriteFrame(2, true, []byte(p)); err != nil {
+			t.Fatalf("WriteFrame failed: %v", err)
+		}
+	}
+	rb.Write([]byte{0, 5, 'k'})
+
+	frames, err := rb.ReadAllFrames(2, true, 3)
+	if err != nil || len(frames) != 3 || string(frames[0]) != "abc" || len(frames[1]) != 0 || string(frames[2]) != "defgh" {
+		t.Fatalf("expect 3 frames but got %q %v", frames, err)
+	}
+	frames, err = rb.ReadAllFrames(2, true, 0)
+	if err != nil || len(frames) != 1 || string(frames[0]) != "ij" {
+		t.Fatalf("expect the last complete frame but got %q %v", frames, err)
+	}
+	if rb.Length() != 3 {
+		t.Fatalf("expect the partial frame to stay buffered but got length %d", rb.Length())
+	}
+
+	rb.Reset()
+	rb.WriteFrame(1, true, []byte("ok"))
+	rb.Write([]byte{200})
+	frames, err = rb.ReadAllFrames(1, true, 10)
+	if err != ErrFrameTooLarge || len(frames) != 1 || rb.Length() != 1 {
+		t.Fatalf("expect ErrFrameTooLarge after 1 frame but got %q %v, length %d", frames, err, rb.Length())
+	}
+}
+
+func TestRingBuffer_SetName(t *testing.T) {
+	rb := New(4)
+	if _, err := rb.Read(make([]byte, 1)); err != ErrIsEmpty {
+		t.Fatalf("expect the bare ErrIsEmpty of an unnamed buffer but got %v", err)
+	}
+	if rb.String() != "ringbuffer: 0/4 bytes" {
+		t.Fatalf("unexpected String %q", rb.String())
+	}
+
+	rb.SetName("ingest")
+	rb.Write([]byte("abc"))
+	if s := rb.String(); s != "ringbuffer ingest: 3/4 bytes" {
+		t.Fatalf("unexpected String %q", s)
+	}
+	_, err := rb.Write([]byte("de"))
+	if !errors.Is(err, ErrTooManyDataToWrite) || err.Error() != "ingest: too many data to write" {
+		t.Fatalf("expect a named ErrTooManyDataToWrite but got %v", err)
+	}
+	if err = rb.WriteByte('x'); !errors.Is(err, ErrIsFull) || !strings.HasPrefix(err.Error(), "ingest: ") {
+		t.Fatalf("expect a named ErrIsFull but got %v", err)
+	}
+	rb.Reset()
+	if _, err = rb.ReadByte(); !errors.Is(err, ErrIsEmpty) || err == ErrIsEmpty {
+		t.Fatalf("expect a named ErrIsEmpty but got %v", err)
+	}
+
+	rb.SetBlocking(true)
+	rb.Close()
+	if _, err = rb.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expect a bare io.EOF but got %v", err)
+	}
+	if s := rb.String(); s != "ringbuffer ingest: 0/4 bytes, closed" {
+		t.Fatalf("unexpected String %q", s)
+	}
+}
+
+func TestRingBuffer_ReadView(t *testing.T) {
+	rb := New(8)
+	if view, commit := rb.ReadView(0); view != nil {
+		t.Fatalf("expect no view of an empty buffer but got %q", view)
+	} else {
+		commit()
+	}
+
+	rb = NewInState([]byte("fg___abc"), 5, 2, false)
+	view, commit := rb.ReadView(2)
+	if string(view) != "ab" {
+		t.Fatalf("expect ab but got %q", view)
+	}
+	if rb.Length() != 5 {
+		t.Fatalf("expect ReadView not to consume before commit but got length %d", rb.Length())
+	}
+	commit()
+	commit()
+	if rb.Length() != 3 {
+		t.Fatalf("expect commit to consume 2 bytes once but got length %d", rb.Length())
+	}
+
+	// 数据跨越终点时只返回到终点为止的一段
+	view, commit = rb.ReadView(0)
+	if string(view) != "c" {
+		t.Fatalf("expect the contiguous part c but got %q", view)
+	}
+	commit()
+	view, commit = rb.ReadView(0)
+	if string(view) != "fg" {
+		t.Fatalf("expect fg but got %q", view)
+	}
+	rb.ReadByte()
+	commit()
+	if rb.Length() != 1 {
+		t.Fatalf("expect a stale commit to do nothing but got length %d", rb.Length())
+	}
+
+	// WriteFront 不改变 readOff，但插到了视图前面
+	rb = New(16)
+	rb.Write([]byte("data"))
+	view, commit = rb.ReadView(0)
+	if string(view) != "data" {
+		t.Fatalf("expect data but got %q", view)
+	}
+	rb.WriteFront([]byte("CTL"))
+	commit()
+	if string(rb.Bytes()) != "CTLdata" {
+		t.Fatalf("expect a commit after WriteFront to do nothing but got %q", rb.Bytes())
+	}
+}
+
+func TestRingBuffer_WriteIfLastByte(t *testing.T) {
+	rb := New(8)
+	if _, err := rb.WriteIfLastByte('\n', []byte("a\n")); err != ErrIsEmpty {
+		t.Fatalf("expect ErrIsEmpty but got %v", err)
+	}
+
+	rb = NewInState([]byte("c_____ab"), 6, 1, false)
+	if _, err := rb.WriteIfLastByte('\n', []byte("x")); err != ErrLastByteMismatch {
+		t.Fatalf("expect ErrLastByteMismatch but got %v", err)
+	}
+	rb.Write([]byte("\n"))
+	if n, err := rb.WriteIfLastByte('\n', []byte("de\n")); n != 3 || err != nil {
+		t.Fatalf("expect to write 3 bytes but got %d %v", n, err)
+	}
+	if string(rb.Bytes()) != "abc\nde\n" {
+		t.Fatalf("expect abc\\nde\\n but got %q", rb.Bytes())
+	}
+
+	// w 在 0 时最后一个 byte 在终点
+	rb = NewInState([]byte("____ab\n!"), 4, 0, false)
+	if _, err := rb.WriteIfLastByte('!', []byte("x")); err != nil {
+		t.Fatalf("expect the last byte before the end to match but got %v", err)
+	}
+}
+
+func TestDoubleBuffer(t *testing.T) {
+	d := NewDoubleBuffer(4)
+	a := d.Active()
+	a.Write([]byte("abcd"))
+	if a.Capacity() != 4 || !a.IsFull() {
+		t.Fatalf("expect a full half of 4 bytes but got %d", a.Capacity())
+	}
+
+	filled, err := d.Flip()
+	if err != nil || filled != a {
+		t.Fatalf("expect Flip to return the filled buffer but got %v", err)
+	}
+	b := d.Active()
+	if b == a || !b.IsEmpty() {
+		t.Fatalf("expect an empty buffer to become active")
+	}
+	// 两个 half 共用一块内存但互不覆盖
+	b.Write([]byte("efgh"))
+	if string(a.Bytes()) != "abcd" {
+		t.Fatalf("expect the standby data to be intact but got %q", a.Bytes())
+	}
+
+	if _, err = d.Flip(); err != ErrNotDrained {
+		t.Fatalf("expect ErrNotDrained but got %v", err)
+	}
+	if d.Active() != b {
+		t.Fatalf("expect a failed Flip to keep the active buffer")
+	}
+	a.Read(make([]byte, 4))
+	if filled, err = d.Flip(); err != nil || filled != b || d.Active() != a {
+		t.Fatalf("expect Flip to swap once drained but got %v", err)
+	}
+}
+
+func TestNewConnPipe(t *testing.T) {
+	a, b := NewConnPipe(8)
+	if n, err := a.Write([]byte("hello")); n != 5 || err != nil {
+		t.Fatalf("expect a buffered write of 5 bytes but got %d %v", n, err)
+	}
+	p := make([]byte, 16)
+	if n, err := b.Read(p); err != nil || string(p[:n]) != "hello" {
+		t.Fatalf("expect hello but got %q %v", p[:n], err)
+	}
+
+	b.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err := b.Read(p)
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expect a timeout but got %v", err)
+	}
+
+	// 新的 deadline 对阻塞中的 Read 也生效
+	b.SetReadDeadline(time.Time{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := b.Read(p)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	b.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if err = <-done; !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expect the pending Read to time out but got %v", err)
+	}
+
+	a.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+	if n, err := a.Write([]byte("0123456789")); n != 8 || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expect a short write of 8 bytes and a timeout but got %d %v", n, err)
+	}
+
+	b.SetReadDeadline(time.Time{})
+	a.Close()
+	if n, err := b.Read(p); err != nil || string(p[:n]) != "01234567" {
+		t.Fatalf("expect the buffered bytes after Close but got %q %v", p[:n], err)
+	}
+	if _, err = b.Read(p); err != io.EOF {
+		t.Fatalf("expect io.EOF but got %v", err)
+	}
+	if _, err = b.Write([]byte("x")); err != io.ErrClosedPipe {
+		t.Fatalf("expect io.ErrClosedPipe but got %v", err)
+	}
+	if _, err = a.Read(p); err != io.ErrClosedPipe {
+		t.Fatalf("expect io.ErrClosedPipe on the closed end but got %v", err)
+	}
+}
+
+func TestRingBuffer_CommitFrameAllOrNothing(t *testing.T) {
+	rb := New(10)
+	rb.ReserveFrame(6)
+	rb.WriteReserved([]byte("frame!"))
+
+	rb.PauseWrites()
+	if err := rb.CommitFrame(); err != ErrPaused {
+		t.Fatalf("expect ErrPaused but got %v", err)
+	}
+	rb.ResumeWrites()
+
+	// soft limit 把预留的空间收走了，frame 不能只写一半
+	rb.Write([]byte("ab"))
+	rb.SetSoftLimit(6)
+	if err := rb.CommitFrame(); err != ErrIsFull {
+		t.Fatalf("expect ErrIsFull but got %v", err)
+	}
+	if string(rb.Bytes()) != "ab" {
+		t.Fatalf("expect no partial frame but got %q", rb.Bytes())
+	}
+
+	rb.SetSoftLimit(0)
+	if err := rb.CommitFrame(); err != nil {
+		t.Fatalf("expect the kept reservation to commit but got %v", err)
+	}
+	if string(rb.Bytes()) != "abframe!" {
+		t.Fatalf("expect abframe! but got %q", rb.Bytes())
+	}
+}
+
+func TestRingBuffer_WriteFrameQuota(t *testing.T) {
+	rb := New(16)
+	rb.SetWriteQuota(4)
+	if err := rb.WriteFrame(2, true, []byte("hello")); err != ErrQuotaExceeded {
+		t.Fatalf("expect ErrQuotaExceeded but got %v", err)
+	}
+	if err := rb.WriteFrameCRC([]byte("x")); err != ErrQuotaExceeded {
+		t.Fatalf("expect ErrQuotaExceeded but got %v", err)
+	}
+	if !rb.IsEmpty() {
+		t.Fatalf("expect no partial frame but got %q", rb.Bytes())
+	}
+	if err := rb.WriteFrame(2, true, []byte("hi")); err != nil {
+		t.Fatalf("expect a frame within the quota but got %v", err)
+	}
+}
+
+func TestPool_PutStopsBackgroundWork(t *testing.T) {
+	p := &Pool{}
+	before := runtime.NumGoroutine()
+	var events <-chan bool
+	for i := 0; i < 20; i++ {
+		rb := p.Get(16)
+		rb.EnableFullnessSampler(time.Millisecond)
+		events = rb.FullnessEvents()
+		p.Put(rb)
+	}
+	deadline := time.Now().Add(time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-events:
+		case <-time.After(time.Until(deadline)):
+			t.Fatalf("expect Put to close the FullnessEvents channel")
+		}
+	}
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("expect Put to stop the samplers but got %d goroutines instead of %d", n, before)
+	}
+}
+
+func TestPool_PutWithArmedTimers(t *testing.T) {
+	p := &Pool{}
+	for i := 0; i < 200; i++ {
+		rb := p.Get(16)
+		rb.EnableFullnessSampler(time.Microsecond)
+		rb.SetIdleFlush(time.Microsecond, func() {})
+		rb.SetStallCallback(time.Microsecond, func(int) {})
+		if _, err := rb.Write([]byte("abcd")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if i%4 == 0 {
+			time.Sleep(time.Microsecond)
+		}
+		p.Put(rb)
+	}
+	rb := p.Get(16)
+	if !rb.IsEmpty() || rb.Free() != 16 {
+		t.Fatalf("expect a recycled buffer to be reset, got %d free", rb.Free())
+	}
+}
+
+func TestRingBuffer_SetBlockingZeroSize(t *testing.T) {
+	rb := New(0)
+	rb.SetBlocking(true)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := rb.Write([]byte("xyz"))
+		done <- err
+	}()
+	p := make([]byte, 2)
+	var got []byte
+	for len(got) < 3 {
+		n, err := rb.Read(p)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		got = append(got, p[:n]...)
+	}
+	if err := <-done; err != nil || string(got) != "xyz" {
+		t.Fatalf("expect xyz handed off but got %q %v", got, err)
+	}
+
+	// 切回非阻塞模式会唤醒等待中的 Read 和 Write
+	readDone := make(chan error, 1)
+	go func() {
+		_, err := rb.Read(p)
+		readDone <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	rb.SetBlocking(false)
+	if err := <-readDone; err != ErrIsEmpty {
+		t.Fatalf("expect ErrIsEmpty once non-blocking but got %v", err)
+	}
+
+	rb.SetBlocking(true)
+	go func() {
+		_, err := rb.Write([]byte("a"))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	rb.Close()
+	if err := <-done; err != ErrIsClosed {
+		t.Fatalf("expect ErrIsClosed but got %v", err)
+	}
+	if _, err := rb.Read(p); err != io.EOF {
+		t.Fatalf("expect io.EOF but got %v", err)
+	}
+}
+
+func TestRingBuffer_ReadFullContext(t *testing.T) {
+	rb := New(2)
+	rb.Write([]byte("ab"))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	buf := make([]byte, 4)
+	n, err := rb.ReadFullContext(ctx, buf)
+	if err != context.DeadlineExceeded || string(buf[:n]) != "ab" {
+		t.Fatalf("expect ab and context.DeadlineExceeded but got %q, %v", buf[:n], err)
+	}
+
+	// 取消时已经缓冲的数据留在 buffer 里
+	rb = New(8)
+	rb.Write([]byte("ab"))
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if n, err = rb.ReadFullContext(ctx, buf); err != context.Canceled || n != 0 || rb.Length() != 2 {
+		t.Fatalf("expect context.Canceled without reading but got %d, %v", n, err)
+	}
+
+	// soft limit 下 buffer 最多存 4 个字节，读 8 个字节时不能一直等 min(8, size)
+	rb = New(16)
+	rb.SetSoftLimit(4)
+	go func() {
+		for i := 0; i < 4; i++ {
+			rb.WriteBlocking([]byte("ab"))
+		}
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	buf = make([]byte, 8)
+	if n, err = rb.ReadFullContext(ctx, buf); err != nil || string(buf[:n]) != "abababab" {
+		t.Fatalf("expect abababab under a soft limit but got %q, %v", buf[:n], err)
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright 2019 smallnest. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ringbuffer
+
+// NewInState returns a RingBuffer over buf with the given read and write positions, full telling apart
+// a full buffer from an empty one when r == w. The readable bytes are those of buf from r up to w, wrapping around.
+// It is meant for tests only, to set up wrap-around states (r > w) directly instead of driving the buffer there
+// with a sequence of writes and reads, and panics if the positions are out of range.
+func NewInState(buf []byte, r, w int, full bool) *RingBuffer {
+	size := len(buf)
+	if size == 0 && (r != 0 || w != 0 || full) {
+		panic("ringbuffer: NewInState positions out of range")
+	}
+	if size > 0 && (r < 0 || r >= size || w < 0 || w >= size) {
+		panic("ringbuffer: NewInState positions out of range")
+	}
+	if full && r != w {
+		panic("ringbuffer: NewInState full buffer needs r == w")
+	}
+
+	rb := newWithBuf(buf)
+	rb.r = r
+	rb.w = w
+	rb.isFull = full
+	rb.written = uint64(rb.length())
+	return rb
+}